@@ -29,6 +29,9 @@ echo "INFO: build test binary file: $bin"
 # grpc api
 go test "grpc"
 
+# http gateway
+go test "gateway"
+
 # benchmark
 dir=src/benchmark/proto_pb_test
 src/rpc/msg_gen.sh --input $dir/msg.proto --output $dir/msg_pbpayload.go --pkgname "proto_pb_test"
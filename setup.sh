@@ -5,6 +5,7 @@ default_go_workspace=`pwd`
 go_lib_deps='github.com/golang/protobuf/proto
 github.com/golang/protobuf/protoc-gen-go
 golang.org/x/net/context
+golang.org/x/net/http2
 google.golang.org/grpc'
 
 bin_deps='protoc
@@ -103,8 +103,9 @@ func main() {
 
 	task := NewTask(*req_num, *conn_num, *burst_num)
 
-	hf := rpc.NewRPCHeaderFactory(rpc.NewProtobufFactory())
-	_ = hf
+	pf := rpc.NewProtobufFactory()
+	pf.Register("rpc", func() rpc.Payload { return testpb.NewTestReq() }, func() rpc.Payload { return testpb.NewTestRep() })
+	hf := rpc.NewRPCHeaderFactory(pf)
 
 	// index 0 is not used
 	var routers []*rpc.Router
@@ -4,7 +4,6 @@ import (
 	testpb "benchmark/proto_pb_test"
 	"flag"
 	"fmt"
-	proto "github.com/golang/protobuf/proto"
 	"os"
 	"os/signal"
 	"rpc"
@@ -17,15 +16,6 @@ func ServiceProcessPayload(r *rpc.Router, name string, p rpc.Payload) rpc.Payloa
 		rep := testpb.NewTestRep()
 		rep.Id = req.Id
 		return rep
-	} else if b, ok := p.([]byte); ok {
-
-		req := testpb.NewTestReq()
-		if proto.Unmarshal(b, req) != nil {
-			panic("proto.Unmarshal error")
-		}
-		rep := testpb.NewTestRep()
-		rep.Id = req.Id
-		return rep
 	} else {
 		panic("ServiceProcessPayload receieve wrong info")
 	}
@@ -48,7 +38,9 @@ func main() {
 	}
 
 	// protocol
-	hf := rpc.NewRPCHeaderFactory(rpc.NewProtobufFactory())
+	pf := rpc.NewProtobufFactory()
+	pf.Register("rpc", func() rpc.Payload { return testpb.NewTestReq() }, func() rpc.Payload { return testpb.NewTestRep() })
+	hf := rpc.NewRPCHeaderFactory(pf)
 
 	r, err := rpc.NewRouter(nil, ServiceProcessPayload)
 	if err != nil {
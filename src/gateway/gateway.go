@@ -0,0 +1,258 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+// Package gateway exposes the rpcs of Router as HTTP/1.1 JSON endpoints:
+//
+//	POST /<rpc name> with JSON body
+//
+// The request is sent to the EndPoint of rpc by Router.CallContext, or served by
+// the local handler. The reply is the JSON body of 200 OK, the status is
+// translated to HTTP code with the JSON body {"code": ..., "message": ...}.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"rpc"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// HTTP header of rpc status.
+	HeaderStatus = "Rpc-Status"
+	// HTTP header prefix of metadata, "Rpc-Metadata-Key: v" is metadata
+	// "key: v".
+	HeaderMetadataPrefix = "Rpc-Metadata-"
+	// HTTP header of call timeout in seconds.
+	HeaderTimeout = "Rpc-Timeout"
+)
+
+// Gateway is http.Handler of rpcs.
+type Gateway struct {
+	r *rpc.Router
+
+	ep  string            // the default EndPoint
+	eps map[string]string // rpc -> EndPoint
+
+	types  map[string]func() rpc.Payload
+	locals map[string]rpc.ServeRPC
+
+	timeout    time.Duration // seconds of call
+	maxtimeout time.Duration // the max seconds of HeaderTimeout
+	maxbody    int64         // the max bytes of request body
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// New returns the Gateway which sends the requests to EndPoint ep of r, ""
+// means only the routed(Route) or local(HandleLocal) rpcs are served.
+func New(r *rpc.Router, ep string) *Gateway {
+	g := new(Gateway)
+	g.r = r
+	g.ep = ep
+	g.eps = make(map[string]string)
+	g.types = make(map[string]func() rpc.Payload)
+	g.locals = make(map[string]rpc.ServeRPC)
+	g.timeout = 5
+	g.maxtimeout = 60
+	g.maxbody = 1024 * 1024
+	return g
+}
+
+// Register sets the request type of rpc which JSON body is decoded into, the
+// body of unregistered rpc is decoded into map[string]interface{}.
+func (g *Gateway) Register(name string, newReq func() rpc.Payload) {
+	g.types[name] = newReq
+}
+
+// Route sends the requests of rpc to EndPoint ep.
+func (g *Gateway) Route(name string, ep string) {
+	g.eps[name] = ep
+}
+
+// HandleLocal serves rpc by h instead of calling an EndPoint, the request is
+// authenticated and authorized by Router(see Router.Authenticate).
+func (g *Gateway) HandleLocal(name string, h rpc.ServeRPC) {
+	g.locals[name] = h
+}
+
+// SetTimeout sets the default call timeout in seconds.
+func (g *Gateway) SetTimeout(n time.Duration) {
+	g.timeout = n
+}
+
+// SetMaxTimeout sets the max call timeout in seconds which can be set by
+// HeaderTimeout, 60 by default.
+func (g *Gateway) SetMaxTimeout(n time.Duration) {
+	g.maxtimeout = n
+}
+
+// SetMaxBodySize sets the max bytes of request body, 1M by default.
+func (g *Gateway) SetMaxBodySize(n int64) {
+	g.maxbody = n
+}
+
+// HTTPStatus translates rpc status to HTTP status code.
+func HTTPStatus(s rpc.Status) int {
+	switch s {
+	case rpc.STATUS_OK:
+		return http.StatusOK
+	case rpc.STATUS_CANCELLED:
+		return 499 // client closed request
+	case rpc.STATUS_INVALID_ARGUMENT, rpc.STATUS_FAILED_PRECONDITION, rpc.STATUS_OUT_OF_RANGE:
+		return http.StatusBadRequest
+	case rpc.STATUS_DEADLINE_EXCEEDED:
+		return http.StatusGatewayTimeout
+	case rpc.STATUS_NOT_FOUND:
+		return http.StatusNotFound
+	case rpc.STATUS_ALREADY_EXISTS, rpc.STATUS_ABORTED:
+		return http.StatusConflict
+	case rpc.STATUS_PERMISSION_DENIED:
+		return http.StatusForbidden
+	case rpc.STATUS_UNAUTHENTICATED:
+		return http.StatusUnauthorized
+	case rpc.STATUS_RESOURCE_EXHAUSTED:
+		return http.StatusTooManyRequests
+	case rpc.STATUS_UNIMPLEMENTED:
+		return http.StatusNotImplemented
+	case rpc.STATUS_UNAVAILABLE:
+		return http.StatusServiceUnavailable
+	}
+	// UNKNOWN, INTERNAL, DATA_LOSS
+	return http.StatusInternalServerError
+}
+
+func (g *Gateway) error(w http.ResponseWriter, err error) {
+	g.errorCode(w, HTTPStatus(rpc.StatusOf(err)), err)
+}
+
+// decodeError replies the error of decoding request body.
+func (g *Gateway) decodeError(w http.ResponseWriter, err error) {
+	if _, ok := err.(*http.MaxBytesError); ok {
+		g.errorCode(w, http.StatusRequestEntityTooLarge, rpc.NewStatusError(rpc.STATUS_RESOURCE_EXHAUSTED, err.Error()))
+		return
+	}
+	g.error(w, rpc.NewStatusError(rpc.STATUS_INVALID_ARGUMENT, err.Error()))
+}
+
+func (g *Gateway) errorCode(w http.ResponseWriter, code int, err error) {
+	s := rpc.StatusOf(err)
+	msg := err.Error()
+	if se, ok := err.(*rpc.StatusError); ok {
+		msg = se.Message()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(HeaderStatus, s.String())
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(&errorBody{Code: s.String(), Message: msg})
+}
+
+// metadata returns the metadata of HTTP request.
+func metadata(req *http.Request) rpc.Metadata {
+	var md rpc.Metadata
+	for k, vs := range req.Header {
+		if len(vs) == 0 {
+			continue
+		}
+
+		var key string
+		if k == "Authorization" {
+			key = rpc.MetaAuthorization
+		} else if strings.HasPrefix(k, HeaderMetadataPrefix) {
+			key = strings.ToLower(k[len(HeaderMetadataPrefix):])
+		} else {
+			continue
+		}
+
+		if md == nil {
+			md = make(rpc.Metadata)
+		}
+		md[key] = vs[0]
+	}
+	return md
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(req.URL.Path, "/")
+	local, isLocal := g.locals[name]
+	ep, exist := g.eps[name]
+	if !exist {
+		ep = g.ep
+	}
+	if name == "" || (!isLocal && ep == "") {
+		g.error(w, rpc.NewStatusError(rpc.STATUS_NOT_FOUND, "rpc not found: "+name))
+		return
+	}
+
+	// decode the request, the empty body is {}.
+	req.Body = http.MaxBytesReader(w, req.Body, g.maxbody)
+	var p rpc.Payload
+	if f, exist := g.types[name]; exist {
+		p = f()
+		if err := json.NewDecoder(req.Body).Decode(p); err != nil && err != io.EOF {
+			g.decodeError(w, err)
+			return
+		}
+	} else {
+		m := make(map[string]interface{})
+		if err := json.NewDecoder(req.Body).Decode(&m); err != nil && err != io.EOF {
+			g.decodeError(w, err)
+			return
+		}
+		p = m
+	}
+
+	md := metadata(req)
+
+	var reply rpc.Payload
+	var err error
+	if isLocal {
+		// authenticated as the request received by Router.
+		ctx := &rpc.RPCContext{Router: g.r, EP: req.RemoteAddr, RPC: name, Metadata: md}
+		if err = g.r.Authenticate(ctx, p); err == nil {
+			reply, err = local(ctx, p)
+		}
+	} else {
+		n := g.timeout
+		if v := req.Header.Get(HeaderTimeout); v != "" {
+			if t, e := strconv.Atoi(v); e == nil && t > 0 {
+				n = time.Duration(t)
+			}
+		}
+		if n > g.maxtimeout {
+			n = g.maxtimeout
+		}
+		if md != nil {
+			p = rpc.WithMetadata(md, p)
+		}
+
+		// the call is done with the HTTP request.
+		ctx, cancel := context.WithTimeout(req.Context(), n*time.Second)
+		reply, err = g.r.CallContext(ctx, ep, name, p)
+		cancel()
+	}
+
+	if err != nil {
+		g.error(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(HeaderStatus, rpc.STATUS_OK.String())
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(reply)
+}
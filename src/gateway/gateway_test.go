@@ -0,0 +1,196 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rpc"
+	"strings"
+	"testing"
+	"time"
+)
+
+type echoReq struct {
+	Id   uint64 `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestGateway(t *testing.T) {
+	hf := rpc.NewRPCHeaderFactory(rpc.NewJSONFactory())
+
+	network := "tcp"
+	address := "localhost:10023"
+
+	server, err := rpc.NewRouter(nil, nil)
+	if err != nil {
+		t.FailNow()
+	}
+	server.HandleRPC("echo", func(ctx *rpc.RPCContext, p rpc.Payload) (rpc.Payload, error) {
+		if ctx.Metadata["user"] != "tao" {
+			return nil, rpc.NewStatusError(rpc.STATUS_PERMISSION_DENIED, "who?")
+		}
+		return p, nil
+	})
+	server.HandleRPC("slow", func(ctx *rpc.RPCContext, p rpc.Payload) (rpc.Payload, error) {
+		time.Sleep(2 * time.Second)
+		return p, nil
+	})
+	server.Run()
+	defer server.Stop()
+
+	client, err := rpc.NewRouter(nil, nil)
+	if err != nil {
+		t.FailNow()
+	}
+	client.Run()
+	defer client.Stop()
+
+	if err := server.ListenAndServe("client", network, address, hf, nil); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := client.Dial("server", network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	g := New(client, "server")
+	g.HandleLocal("local", func(ctx *rpc.RPCContext, p rpc.Payload) (rpc.Payload, error) {
+		req := p.(*echoReq)
+		return &echoReq{Id: req.Id + 1, Name: ctx.RPC}, nil
+	})
+	g.Register("local", func() rpc.Payload { return new(echoReq) })
+
+	hs := httptest.NewServer(g)
+	defer hs.Close()
+
+	post := func(name string, body string, header map[string]string) (*http.Response, map[string]interface{}) {
+		req, _ := http.NewRequest("POST", hs.URL+"/"+name, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		defer resp.Body.Close()
+
+		m := make(map[string]interface{})
+		json.NewDecoder(resp.Body).Decode(&m)
+		return resp, m
+	}
+
+	// remote
+	if resp, m := post("echo", `{"id": 1}`, map[string]string{"Rpc-Metadata-User": "tao"}); resp.StatusCode != http.StatusOK || m["id"].(float64) != 1 {
+		t.Log(resp, m)
+		t.FailNow()
+	}
+	if resp, m := post("echo", `{"id": 1}`, nil); resp.StatusCode != http.StatusForbidden || m["code"] != "PERMISSION_DENIED" || m["message"] != "who?" {
+		t.Log(resp, m)
+		t.FailNow()
+	}
+
+	// local
+	if resp, m := post("local", `{"id": 1}`, nil); resp.StatusCode != http.StatusOK || m["id"].(float64) != 2 || m["name"] != "local" {
+		t.Log(resp, m)
+		t.FailNow()
+	}
+
+	// invalid
+	if resp, _ := post("local", `{`, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Log(resp)
+		t.FailNow()
+	}
+	if resp, err := http.Get(hs.URL + "/echo"); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Log(resp, err)
+		t.FailNow()
+	}
+
+	// no route
+	g.Route("lost", "nowhere")
+	if resp, _ := post("lost", `{}`, nil); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Log(resp)
+		t.FailNow()
+	}
+
+	// the body is limited
+	g.SetMaxBodySize(16)
+	if resp, _ := post("echo", `{"name": "`+strings.Repeat("x", 32)+`"}`, map[string]string{"Rpc-Metadata-User": "tao"}); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Log(resp)
+		t.FailNow()
+	}
+
+	// the timeout is capped
+	g.SetMaxTimeout(1)
+	start := time.Now()
+	if resp, _ := post("slow", `{}`, map[string]string{"Rpc-Timeout": "100"}); resp.StatusCode != http.StatusGatewayTimeout || time.Since(start) > 1500*time.Millisecond {
+		t.Log(resp, time.Since(start))
+		t.FailNow()
+	}
+}
+
+func TestGatewayLocalAuth(t *testing.T) {
+	r, err := rpc.NewRouter(nil, nil)
+	if err != nil {
+		t.FailNow()
+	}
+	r.SetAuthenticator(&rpc.TokenAuth{Verify: func(token string) (string, error) {
+		if token != "secret" {
+			return "", rpc.ErrUnauthenticated
+		}
+		return "tao", nil
+	}})
+	r.Authorize("admin", func(ctx *rpc.RPCContext) bool {
+		return ctx.Identity == "admin"
+	})
+	r.Run()
+	defer r.Stop()
+
+	g := New(r, "")
+	echo := func(ctx *rpc.RPCContext, p rpc.Payload) (rpc.Payload, error) {
+		return &echoReq{Name: ctx.Identity}, nil
+	}
+	g.HandleLocal("echo", echo)
+	g.HandleLocal("admin", echo)
+
+	hs := httptest.NewServer(g)
+	defer hs.Close()
+
+	post := func(name string, token string) (*http.Response, map[string]interface{}) {
+		req, _ := http.NewRequest("POST", hs.URL+"/"+name, strings.NewReader(`{}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		defer resp.Body.Close()
+
+		m := make(map[string]interface{})
+		json.NewDecoder(resp.Body).Decode(&m)
+		return resp, m
+	}
+
+	if resp, m := post("echo", "secret"); resp.StatusCode != http.StatusOK || m["name"] != "tao" {
+		t.Log(resp, m)
+		t.FailNow()
+	}
+	if resp, _ := post("echo", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Log(resp)
+		t.FailNow()
+	}
+	if resp, _ := post("echo", "guess"); resp.StatusCode != http.StatusUnauthorized {
+		t.Log(resp)
+		t.FailNow()
+	}
+	if resp, _ := post("admin", "secret"); resp.StatusCode != http.StatusForbidden {
+		t.Log(resp)
+		t.FailNow()
+	}
+}
@@ -4,24 +4,83 @@
 package grpc
 
 import (
+	"context"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"grpc/metadata"
 	"net"
+	"reflect"
 	"rpc"
-	pbt "rpc/pb_test"
+	"strings"
+	"time"
 )
 
 type Server struct {
 	r *rpc.Router
 
 	ln string
+	pf rpc.RPCPayloadFactory
 	mf rpc.MsgFactory
+
+	opts options
+}
+
+// UnaryServerInfo is the rpc info of UnaryServerInterceptor.
+type UnaryServerInfo struct {
+	Server     interface{}
+	FullMethod string
+}
+
+type UnaryHandler func(ctx context.Context, req interface{}) (interface{}, error)
+
+type UnaryServerInterceptor func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error)
+
+type methodHandler func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor UnaryServerInterceptor) (interface{}, error)
+
+// MethodDesc is the unary method of service.
+type MethodDesc struct {
+	MethodName string
+	Handler    methodHandler
 }
 
+type StreamHandler func(srv interface{}, stream ServerStream) error
+
+// StreamDesc is the streaming method of service.
+type StreamDesc struct {
+	StreamName    string
+	Handler       StreamHandler
+	ServerStreams bool
+	ClientStreams bool
+}
+
+// ServiceDesc is the service generated for grpc-go, the method is served as
+// rpc "ServiceName/MethodName" of Router.
 type ServiceDesc struct {
+	ServiceName string
+	// the pointer to the interface of service, ss of RegisterService
+	// implements it.
+	HandlerType interface{}
+	Methods     []MethodDesc
+	Streams     []StreamDesc
+	Metadata    interface{}
+}
+
+// ServerStream is the stream of server side.
+type ServerStream interface {
+	// TODO: headers and trailers are not sent.
+	SetHeader(metadata.MD) error
+	SendHeader(metadata.MD) error
+	SetTrailer(metadata.MD)
+	Context() context.Context
+	SendMsg(m interface{}) error
+	RecvMsg(m interface{}) error
 }
 
 type ServerOption func(*options)
 
-type options struct{}
+type options struct {
+	unaryInt UnaryServerInterceptor
+}
 type Credentials interface{}
 type Codec interface{}
 
@@ -43,21 +102,162 @@ func MaxConcurrentStreams(n uint32) ServerOption {
 	}
 }
 
+// UnaryInterceptor sets the interceptor of unary methods.
+func UnaryInterceptor(i UnaryServerInterceptor) ServerOption {
+	return func(o *options) {
+		o.unaryInt = i
+	}
+}
+
+// Errorf returns the error of status c.
+func Errorf(c rpc.Status, format string, a ...interface{}) error {
+	return rpc.NewStatusError(c, fmt.Sprintf(format, a...))
+}
+
+// Code returns the status of err.
+func Code(err error) rpc.Status {
+	return rpc.StatusOf(err)
+}
+
 func NewServer(opt ...ServerOption) *Server {
 	var err error
 	s := new(Server)
 
+	for _, o := range opt {
+		o(&s.opts)
+	}
+
 	if s.r, err = rpc.NewRouter(nil, nil); err != nil {
-		return nil
+		panic(fmt.Sprintf("grpc: NewServer failed: %v", err))
 	}
 
 	s.ln = "grpc-api-listener"
-	s.mf = rpc.NewMsgHeaderFactory(pbt.NewMsgProtobufFactory())
+	// the messages are decoded by the handlers(see decode).
+	s.pf = rpc.NewProtobufFactory()
+	s.mf = rpc.NewRPCHeaderFactory(s.pf)
 
 	return s
 }
 
+type methodKey struct{}
+
+// Method returns the full method name("/service/method") of the server ctx.
+func Method(ctx context.Context) (string, bool) {
+	m, ok := ctx.Value(methodKey{}).(string)
+	return m, ok
+}
+
+// newContext returns the server ctx of method name with the incoming md, it is
+// done at the deadline of caller or when cancel is called.
+func newContext(name string, md rpc.Metadata, deadline time.Time) (context.Context, context.CancelFunc) {
+	ctx := context.WithValue(context.Background(), methodKey{}, "/"+name)
+	if md != nil {
+		imd := metadata.New(md)
+		// the deadline of ctx
+		delete(imd, rpc.MetaTimeout)
+		ctx = metadata.NewIncomingContext(ctx, imd)
+	}
+
+	if deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, deadline)
+}
+
+// decode decodes the message p into v, the payload of unregistered rpc is
+// the raw bytes.
+func decode(p rpc.Payload, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return Errorf(rpc.STATUS_INTERNAL, "grpc: %T is not proto.Message", v)
+	}
+
+	switch b := p.(type) {
+	case nil:
+		m.Reset()
+	case []byte:
+		if err := proto.Unmarshal(b, m); err != nil {
+			return Errorf(rpc.STATUS_INVALID_ARGUMENT, "grpc: %v", err)
+		}
+	case proto.Message:
+		m.Reset()
+		proto.Merge(m, b)
+	default:
+		return Errorf(rpc.STATUS_INTERNAL, "grpc: unexpected payload %T", p)
+	}
+	return nil
+}
+
+// RegisterService registers the methods of sd served by ss. It should be
+// called before Serve.
 func (s *Server) RegisterService(sd *ServiceDesc, ss interface{}) {
+	if ss != nil && sd.HandlerType != nil {
+		ht := reflect.TypeOf(sd.HandlerType).Elem()
+		if st := reflect.TypeOf(ss); !st.Implements(ht) {
+			panic(fmt.Sprintf("grpc: Server.RegisterService found the handler of type %v that does not satisfy %v", st, ht))
+		}
+	}
+
+	for _, m := range sd.Methods {
+		name := sd.ServiceName + "/" + m.MethodName
+		s.r.HandleRPC(name, s.serveMethod(ss, name, m.Handler))
+	}
+	for _, st := range sd.Streams {
+		s.r.HandleStream(sd.ServiceName+"/"+st.StreamName, s.serveStream(ss, st.Handler))
+	}
+}
+
+func (s *Server) serveMethod(ss interface{}, name string, h methodHandler) rpc.ServeRPC {
+	return func(ctx *rpc.RPCContext, p rpc.Payload) (rpc.Payload, error) {
+		dec := func(v interface{}) error {
+			return decode(p, v)
+		}
+		c, cancel := newContext(name, ctx.Metadata, ctx.Deadline)
+		defer cancel()
+		return h(ss, c, dec, s.opts.unaryInt)
+	}
+}
+
+type serverStream struct {
+	s   *rpc.Stream
+	ctx context.Context
+}
+
+func (ss *serverStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+func (ss *serverStream) SendHeader(metadata.MD) error {
+	return nil
+}
+
+func (ss *serverStream) SetTrailer(metadata.MD) {
+}
+
+func (ss *serverStream) Context() context.Context {
+	return ss.ctx
+}
+
+func (ss *serverStream) SendMsg(m interface{}) error {
+	return ss.s.Send(m)
+}
+
+func (ss *serverStream) RecvMsg(m interface{}) error {
+	p, err := ss.s.Recv()
+	if err != nil {
+		return err
+	}
+	return decode(p, m)
+}
+
+func (s *Server) serveStream(ss interface{}, h StreamHandler) rpc.ServeStream {
+	return func(r *rpc.Router, st *rpc.Stream) {
+		ctx, cancel := newContext(st.GetRPCName(), st.GetMetadata(), st.When())
+		defer cancel()
+		if err := h(ss, &serverStream{s: st, ctx: ctx}); err != nil {
+			st.CloseSendError(err)
+		}
+	}
 }
 
 func (s *Server) Serve(lis net.Listener) error {
@@ -96,6 +296,29 @@ type dialOptions struct {
 
 type DialOption func(*dialOptions)
 
+type callInfo struct {
+}
+
+// CallOption configures the call of Invoke and NewStream.
+type CallOption func(*callInfo)
+
+// ClientConnInterface is used by the generated client stubs.
+type ClientConnInterface interface {
+	Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...CallOption) error
+	NewStream(ctx context.Context, desc *StreamDesc, method string, opts ...CallOption) (ClientStream, error)
+}
+
+// ClientStream is the stream of client side.
+type ClientStream interface {
+	// TODO: headers and trailers are not received.
+	Header() (metadata.MD, error)
+	Trailer() metadata.MD
+	CloseSend() error
+	Context() context.Context
+	SendMsg(m interface{}) error
+	RecvMsg(m interface{}) error
+}
+
 func Dial(target string, opts ...DialOption) (*ClientConn, error) {
 	var err error
 	cc := new(ClientConn)
@@ -107,7 +330,7 @@ func Dial(target string, opts ...DialOption) (*ClientConn, error) {
 	cc.r.Run()
 
 	cc.cn = "grpc-api-connector"
-	cc.mf = rpc.NewMsgHeaderFactory(pbt.NewMsgProtobufFactory())
+	cc.mf = rpc.NewRPCHeaderFactory(rpc.NewProtobufFactory())
 
 	if err = cc.r.Dial(cc.cn, "tcp", target, cc.mf); err != nil {
 		cc.r.Stop()
@@ -122,5 +345,87 @@ func (cc *ClientConn) Close() error {
 	return nil
 }
 
+// rpcMetadata returns the outgoing metadata of ctx, only the first value of
+// each key is sent.
+func rpcMetadata(ctx context.Context) rpc.Metadata {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok || md.Len() == 0 {
+		return nil
+	}
+
+	m := make(rpc.Metadata, md.Len())
+	for k, vs := range md {
+		if len(vs) > 0 {
+			m[k] = vs[0]
+		}
+	}
+	return m
+}
+
+// Invoke calls the unary method("/service/method") with the deadline of ctx,
+// the reply is decoded into reply.
+func (cc *ClientConn) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...CallOption) error {
+	var p rpc.Payload = args
+	if md := rpcMetadata(ctx); md != nil {
+		p = rpc.WithMetadata(md, p)
+	}
+
+	rp, err := cc.r.CallContext(ctx, cc.cn, strings.TrimPrefix(method, "/"), p)
+	if err != nil {
+		return err
+	}
+	return decode(rp, reply)
+}
+
+type clientStream struct {
+	s   *rpc.Stream
+	ctx context.Context
+}
+
+func (cs *clientStream) Header() (metadata.MD, error) {
+	return metadata.MD{}, nil
+}
+
+func (cs *clientStream) Trailer() metadata.MD {
+	return metadata.MD{}
+}
+
+func (cs *clientStream) CloseSend() error {
+	return cs.s.CloseSend()
+}
+
+func (cs *clientStream) Context() context.Context {
+	return cs.ctx
+}
+
+func (cs *clientStream) SendMsg(m interface{}) error {
+	if err := cs.ctx.Err(); err != nil {
+		return err
+	}
+	return cs.s.Send(m)
+}
+
+func (cs *clientStream) RecvMsg(m interface{}) error {
+	if err := cs.ctx.Err(); err != nil {
+		return err
+	}
+
+	p, err := cs.s.Recv()
+	if err != nil {
+		return err
+	}
+	return decode(p, m)
+}
+
+// NewStream opens the stream of method("/service/method") with the outgoing
+// metadata of ctx, it is failed when ctx is done.
+func (cc *ClientConn) NewStream(ctx context.Context, desc *StreamDesc, method string, opts ...CallOption) (ClientStream, error) {
+	s, err := cc.r.NewStreamMetadata(ctx, cc.cn, strings.TrimPrefix(method, "/"), rpcMetadata(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &clientStream{s: s, ctx: ctx}, nil
+}
+
 // TODO: func (cc *ClientConn) State() (ConnectivityState, error)
 // TODO: func (cc *ClientConn) WaitForStateChange(ctx context.Context, sourceState ConnectivityState) (ConnectivityState, error)
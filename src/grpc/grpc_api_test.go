@@ -4,8 +4,15 @@
 package grpc
 
 import (
+	"context"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"grpc/metadata"
+	"io"
 	"net"
+	"rpc"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGRPC(t *testing.T) {
@@ -26,3 +33,417 @@ func TestGRPC(t *testing.T) {
 	}
 	defer c.Close()
 }
+
+// The service as generated for grpc-go.
+type echoServer interface {
+	Say(context.Context, *wrapperspb.StringValue) (*wrapperspb.StringValue, error)
+	Stream(echoStreamServer) error
+}
+
+type echoStreamServer interface {
+	Send(*wrapperspb.StringValue) error
+	Recv() (*wrapperspb.StringValue, error)
+	ServerStream
+}
+
+type echoStreamServerImpl struct {
+	ServerStream
+}
+
+func (x *echoStreamServerImpl) Send(m *wrapperspb.StringValue) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *echoStreamServerImpl) Recv() (*wrapperspb.StringValue, error) {
+	m := new(wrapperspb.StringValue)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Echo_Say_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor UnaryServerInterceptor) (interface{}, error) {
+	in := new(wrapperspb.StringValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(echoServer).Say(ctx, in)
+	}
+	info := &UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/test.Echo/Say",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(echoServer).Say(ctx, req.(*wrapperspb.StringValue))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Echo_Stream_Handler(srv interface{}, stream ServerStream) error {
+	return srv.(echoServer).Stream(&echoStreamServerImpl{stream})
+}
+
+var _Echo_serviceDesc = ServiceDesc{
+	ServiceName: "test.Echo",
+	HandlerType: (*echoServer)(nil),
+	Methods: []MethodDesc{
+		{
+			MethodName: "Say",
+			Handler:    _Echo_Say_Handler,
+		},
+	},
+	Streams: []StreamDesc{
+		{
+			StreamName:    "Stream",
+			Handler:       _Echo_Stream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "echo.proto",
+}
+
+// The client stub as generated for grpc-go.
+type echoClient interface {
+	Say(ctx context.Context, in *wrapperspb.StringValue, opts ...CallOption) (*wrapperspb.StringValue, error)
+	Stream(ctx context.Context, opts ...CallOption) (echoStreamClient, error)
+}
+
+type echoClientImpl struct {
+	cc ClientConnInterface
+}
+
+func newEchoClient(cc ClientConnInterface) echoClient {
+	return &echoClientImpl{cc}
+}
+
+func (c *echoClientImpl) Say(ctx context.Context, in *wrapperspb.StringValue, opts ...CallOption) (*wrapperspb.StringValue, error) {
+	out := new(wrapperspb.StringValue)
+	err := c.cc.Invoke(ctx, "/test.Echo/Say", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *echoClientImpl) Stream(ctx context.Context, opts ...CallOption) (echoStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Echo_serviceDesc.Streams[0], "/test.Echo/Stream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &echoStreamClientImpl{stream}, nil
+}
+
+type echoStreamClient interface {
+	Send(*wrapperspb.StringValue) error
+	Recv() (*wrapperspb.StringValue, error)
+	ClientStream
+}
+
+type echoStreamClientImpl struct {
+	ClientStream
+}
+
+func (x *echoStreamClientImpl) Send(m *wrapperspb.StringValue) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *echoStreamClientImpl) Recv() (*wrapperspb.StringValue, error) {
+	m := new(wrapperspb.StringValue)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+type echoImpl struct{}
+
+func (e *echoImpl) Say(ctx context.Context, in *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	switch in.GetValue() {
+	case "fail":
+		return nil, Errorf(rpc.STATUS_NOT_FOUND, "not found")
+	case "slow":
+		time.Sleep(300 * time.Millisecond)
+	case "deadline":
+		if err := checkDeadline(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	method, _ := Method(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	return wrapperspb.String(in.GetValue() + method + strings.Join(md.Get("key"), "")), nil
+}
+
+// checkDeadline fails if ctx has no deadline of caller(5 seconds).
+func checkDeadline(ctx context.Context) error {
+	if d, ok := ctx.Deadline(); !ok || time.Until(d) <= 0 || time.Until(d) > 5*time.Second {
+		return Errorf(rpc.STATUS_FAILED_PRECONDITION, "deadline %v", d)
+	}
+	return nil
+}
+
+func (e *echoImpl) Stream(s echoStreamServer) error {
+	for {
+		in, err := s.Recv()
+		if err != nil {
+			return nil
+		}
+		switch v := in.GetValue(); {
+		case v == "fail":
+			return Errorf(rpc.STATUS_NOT_FOUND, "not found")
+		case v == "deadline":
+			if err := checkDeadline(s.Context()); err != nil {
+				return err
+			}
+		case strings.HasPrefix(v, "md:"):
+			// the value of metadata key
+			md, _ := metadata.FromIncomingContext(s.Context())
+			in = wrapperspb.String(strings.Join(md.Get(v[len("md:"):]), ""))
+		}
+		if err := s.Send(in); err != nil {
+			return err
+		}
+	}
+}
+
+func TestRegisterService(t *testing.T) {
+	addr := "localhost:12346"
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.FailNow()
+	}
+
+	var intercepted string
+	s := NewServer(UnaryInterceptor(func(ctx context.Context, req interface{}, info *UnaryServerInfo, handler UnaryHandler) (interface{}, error) {
+		intercepted = info.FullMethod
+		return handler(ctx, req)
+	}))
+	s.RegisterService(&_Echo_serviceDesc, &echoImpl{})
+	go s.Serve(l)
+	defer s.Stop()
+
+	// the registered types are decoded by rpc.
+	pf := rpc.NewProtobufFactory()
+	pf.Register("test.Echo/Say", func() rpc.Payload { return new(wrapperspb.StringValue) }, func() rpc.Payload { return new(wrapperspb.StringValue) })
+	pf.Register("test.Echo/Stream", func() rpc.Payload { return new(wrapperspb.StringValue) }, func() rpc.Payload { return new(wrapperspb.StringValue) })
+
+	r, err := rpc.NewRouter(nil, nil)
+	if err != nil {
+		t.FailNow()
+	}
+	r.SetAuthenticator(&rpc.TokenAuth{Token: "secret"})
+	r.Run()
+	defer r.Stop()
+
+	if err := r.Dial("server", "tcp", addr, rpc.NewRPCHeaderFactory(pf)); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	md := rpc.Metadata{"key": "value"}
+	if p, err := r.CallWait("server", "test.Echo/Say", rpc.WithMetadata(md, wrapperspb.String("hello ")), 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if v := p.(*wrapperspb.StringValue).GetValue(); v != "hello /test.Echo/Sayvalue" || intercepted != "/test.Echo/Say" {
+		t.Log(v, intercepted)
+		t.FailNow()
+	}
+
+	if _, err := r.CallWait("server", "test.Echo/Say", wrapperspb.String("fail"), 5); Code(err) != rpc.STATUS_NOT_FOUND {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// the handler gets the deadline of caller
+	if _, err := r.CallWait("server", "test.Echo/Say", wrapperspb.String("deadline"), 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	st, err := r.NewStream("server", "test.Echo/Stream", 5)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	for i := 0; i < 3; i++ {
+		if err := st.Send(wrapperspb.String("s")); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if p, err := st.Recv(); err != nil || p.(*wrapperspb.StringValue).GetValue() != "s" {
+			t.Log(p, err)
+			t.FailNow()
+		}
+	}
+
+	// the metadata and deadline of stream
+	if err := st.Send(wrapperspb.String("md:authorization")); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if p, err := st.Recv(); err != nil || p.(*wrapperspb.StringValue).GetValue() != "Bearer secret" {
+		t.Log(p, err)
+		t.FailNow()
+	}
+	if err := st.Send(wrapperspb.String("deadline")); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if p, err := st.Recv(); err != nil || p.(*wrapperspb.StringValue).GetValue() != "deadline" {
+		t.Log(p, err)
+		t.FailNow()
+	}
+
+	st.CloseSend()
+	if _, err := st.Recv(); err != io.EOF {
+		t.Log(err)
+		t.FailNow()
+	}
+}
+
+func TestRegisterServiceType(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.FailNow()
+		}
+	}()
+
+	s := NewServer()
+	s.RegisterService(&_Echo_serviceDesc, struct{}{})
+}
+
+func TestClientConn(t *testing.T) {
+	addr := "localhost:12347"
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.FailNow()
+	}
+
+	s := NewServer()
+	s.RegisterService(&_Echo_serviceDesc, &echoImpl{})
+	go s.Serve(l)
+	defer s.Stop()
+
+	cc, err := Dial(addr)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer cc.Close()
+
+	c := newEchoClient(cc)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "key", "value")
+	if out, err := c.Say(ctx, wrapperspb.String("hello ")); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if out.GetValue() != "hello /test.Echo/Sayvalue" {
+		t.Log(out)
+		t.FailNow()
+	}
+
+	if _, err := c.Say(ctx, wrapperspb.String("fail")); Code(err) != rpc.STATUS_NOT_FOUND {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// unknown method
+	if err := cc.Invoke(ctx, "/no.Such/Method", wrapperspb.String("hello"), new(wrapperspb.StringValue)); Code(err) != rpc.STATUS_UNIMPLEMENTED {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// deadline
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.Say(tctx, wrapperspb.String("slow")); Code(err) != rpc.STATUS_DEADLINE_EXCEEDED || time.Since(start) > 250*time.Millisecond {
+		t.Log(err, time.Since(start))
+		t.FailNow()
+	}
+
+	// cancelled
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Say(cctx, wrapperspb.String("hello")); Code(err) != rpc.STATUS_CANCELLED {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// stream
+	st, err := c.Stream(ctx)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	for i := 0; i < 3; i++ {
+		if err := st.Send(wrapperspb.String("s")); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if out, err := st.Recv(); err != nil || out.GetValue() != "s" {
+			t.Log(out, err)
+			t.FailNow()
+		}
+	}
+	// the outgoing metadata is sent
+	if err := st.Send(wrapperspb.String("md:key")); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if out, err := st.Recv(); err != nil || out.GetValue() != "value" {
+		t.Log(out, err)
+		t.FailNow()
+	}
+	st.CloseSend()
+	if _, err := st.Recv(); err != io.EOF {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// the error of stream handler
+	if st, err = c.Stream(context.Background()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := st.Send(wrapperspb.String("fail")); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := st.Recv(); Code(err) != rpc.STATUS_NOT_FOUND {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// the stream fails at the deadline
+	tctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if st, err = c.Stream(tctx); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := st.Recv(); Code(err) != rpc.STATUS_DEADLINE_EXCEEDED {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// the blocked RecvMsg returns when ctx is canceled
+	cctx, cancel = context.WithCancel(context.Background())
+	if st, err = c.Stream(cctx); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	start = time.Now()
+	if _, err := st.Recv(); Code(err) != rpc.STATUS_CANCELLED || time.Since(start) > time.Second {
+		t.Log(err, time.Since(start))
+		t.FailNow()
+	}
+}
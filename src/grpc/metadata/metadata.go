@@ -0,0 +1,114 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+// Package metadata mimics google.golang.org/grpc/metadata, the metadata is
+// sent as rpc.Metadata which keeps the first value of each key.
+package metadata
+
+import (
+	"context"
+	"strings"
+)
+
+// MD is the metadata, the keys are lowercase.
+type MD map[string][]string
+
+// New returns MD of m.
+func New(m map[string]string) MD {
+	md := make(MD, len(m))
+	for k, v := range m {
+		md.Set(k, v)
+	}
+	return md
+}
+
+// Pairs returns MD of key/value pairs, it panics if len(kv) is odd.
+func Pairs(kv ...string) MD {
+	if len(kv)%2 == 1 {
+		panic("metadata: Pairs got the odd number of kv")
+	}
+
+	md := make(MD, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		md.Append(kv[i], kv[i+1])
+	}
+	return md
+}
+
+func (md MD) Len() int {
+	return len(md)
+}
+
+func (md MD) Copy() MD {
+	n := make(MD, len(md))
+	for k, vs := range md {
+		n[k] = append([]string(nil), vs...)
+	}
+	return n
+}
+
+func (md MD) Get(k string) []string {
+	return md[strings.ToLower(k)]
+}
+
+func (md MD) Set(k string, vals ...string) {
+	if len(vals) == 0 {
+		return
+	}
+	md[strings.ToLower(k)] = vals
+}
+
+func (md MD) Append(k string, vals ...string) {
+	if len(vals) == 0 {
+		return
+	}
+	k = strings.ToLower(k)
+	md[k] = append(md[k], vals...)
+}
+
+// Join returns the union of mds.
+func Join(mds ...MD) MD {
+	n := MD{}
+	for _, md := range mds {
+		for k, vs := range md {
+			n[k] = append(n[k], vs...)
+		}
+	}
+	return n
+}
+
+type mdIncomingKey struct{}
+type mdOutgoingKey struct{}
+
+func NewIncomingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, mdIncomingKey{}, md)
+}
+
+// FromIncomingContext returns the metadata of request.
+func FromIncomingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(mdIncomingKey{}).(MD)
+	if !ok {
+		return nil, false
+	}
+	return md.Copy(), true
+}
+
+// NewOutgoingContext returns ctx which md is sent with the calls.
+func NewOutgoingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, mdOutgoingKey{}, md)
+}
+
+func FromOutgoingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(mdOutgoingKey{}).(MD)
+	if !ok {
+		return nil, false
+	}
+	return md.Copy(), true
+}
+
+// AppendToOutgoingContext returns ctx which key/value pairs kv are appended to
+// the outgoing metadata.
+func AppendToOutgoingContext(ctx context.Context, kv ...string) context.Context {
+	md, _ := FromOutgoingContext(ctx)
+	return NewOutgoingContext(ctx, Join(md, Pairs(kv...)))
+}
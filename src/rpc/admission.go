@@ -0,0 +1,132 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"time"
+)
+
+var (
+	ErrOverloaded error = &StatusError{code: STATUS_RESOURCE_EXHAUSTED, msg: "server overloaded"}
+)
+
+// AdmissionPolicy sheds rpc requests before they are served.
+//
+// The concurrency limit of handlers starts from MaxConcurrency, it is adapted
+// by handler latency if TargetLatency is set: decreased multiplicatively if
+// the latency is above target, increased additively if not.
+//
+// The request waits in router queue longer than QueueDelay for QueueInterval
+// is shed(CoDel).
+type AdmissionPolicy struct {
+	MaxConcurrency int
+	MinConcurrency int
+	TargetLatency  time.Duration
+
+	QueueDelay    time.Duration
+	QueueInterval time.Duration
+}
+
+// admission is accessed inside router goroutine.
+type admission struct {
+	ap *AdmissionPolicy
+
+	running int
+	limit   float64
+
+	above time.Time // queue delay is above target since
+}
+
+// SetAdmission enables admission control of rpc requests, nil disables it. It
+// should be called before Run().
+func (r *Router) SetAdmission(ap *AdmissionPolicy) {
+	if ap == nil {
+		r.adm = nil
+		return
+	}
+
+	if ap.MinConcurrency <= 0 {
+		ap.MinConcurrency = 1
+	}
+	if ap.QueueInterval <= 0 {
+		ap.QueueInterval = 100 * time.Millisecond
+	}
+
+	r.adm = &admission{ap: ap, limit: float64(ap.MaxConcurrency)}
+}
+
+// admit decides whether request m is served.
+func (r *Router) admit(m *routeMsg) bool {
+	a := r.adm
+
+	if a.ap.QueueDelay > 0 {
+		now := time.Now()
+		if now.Sub(m.recv) < a.ap.QueueDelay {
+			a.above = time.Time{}
+		} else if a.above.IsZero() {
+			a.above = now
+		} else if now.Sub(a.above) >= a.ap.QueueInterval {
+			return false
+		}
+	}
+
+	if a.ap.MaxConcurrency > 0 && float64(a.running) >= a.limit {
+		return false
+	}
+
+	a.running++
+	return true
+}
+
+// admitDone records the latency of served request.
+func (r *Router) admitDone(d time.Duration) {
+	a := r.adm
+	if a == nil {
+		return
+	}
+	a.running--
+
+	if a.ap.TargetLatency <= 0 || a.ap.MaxConcurrency <= 0 {
+		return
+	}
+
+	if d > a.ap.TargetLatency {
+		a.limit *= 0.9
+		if min := float64(a.ap.MinConcurrency); a.limit < min {
+			a.limit = min
+		}
+	} else {
+		a.limit += 1 / a.limit
+		if max := float64(a.ap.MaxConcurrency); a.limit > max {
+			a.limit = max
+		}
+	}
+}
+
+// shed replies ErrOverloaded to request m.
+func (r *Router) shed(m *routeMsg) {
+	r.stats.rpcShed++
+
+	// never wait inside router goroutine
+	var out *routeMsg
+	if v := r.serverOutMsgs.TryGet(); v == nil {
+		return
+	} else {
+		out = v.(*routeMsg)
+	}
+
+	out.Reset()
+	out.ep_name = m.ep_name
+	out.rpc = m.rpc
+	out.id = m.id
+	out.prio = m.prio
+	out.is_rpc = true
+	out.status = STATUS_RESOURCE_EXHAUSTED
+	out.status_msg = ErrOverloaded.(*StatusError).msg
+	out.cb = serve_done
+	out.r = r
+
+	r.stats.msgOut++
+	r.writeOut(out)
+}
@@ -0,0 +1,83 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRouterAdmission(t *testing.T) {
+	pf := NewJSONFactory()
+	hf := NewRPCHeaderFactory(pf)
+
+	network := "tcp"
+	address := "localhost:10018"
+
+	server, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	server.HandleRPC("slow", func(ctx *RPCContext, p Payload) (Payload, error) {
+		time.Sleep(300 * time.Millisecond)
+		return p, nil
+	})
+	server.SetAdmission(&AdmissionPolicy{MaxConcurrency: 2, TargetLatency: 100 * time.Millisecond})
+	server.Run()
+	defer server.Stop()
+
+	client, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	client.Run()
+	defer client.Stop()
+
+	if err := server.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := client.Dial("server", network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			_, errs[i] = client.CallWait("server", "slow", nil, 5)
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+
+	shed := 0
+	for _, err := range errs {
+		if err == nil {
+			continue
+		} else if se, ok := err.(*StatusError); !ok || se.Code() != STATUS_RESOURCE_EXHAUSTED {
+			t.Log(err)
+			t.FailNow()
+		}
+		shed++
+	}
+	if shed != 2 {
+		t.Log(errs)
+		t.FailNow()
+	}
+
+	// the latency is above target, the limit is decreased.
+	if server.adm.limit >= 2 {
+		t.Log(server.adm.limit)
+		t.FailNow()
+	}
+
+	if server.stats.rpcShed != 2 {
+		t.Log(&server.stats)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/golang/protobuf/proto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnauthenticated  error = &StatusError{code: STATUS_UNAUTHENTICATED, msg: "unauthenticated"}
+	ErrPermissionDenied error = &StatusError{code: STATUS_PERMISSION_DENIED, msg: "permission denied"}
+)
+
+const (
+	MetaAuthorization = "authorization"
+	MetaKeyID         = "rpc-key-id"
+	MetaTimestamp     = "rpc-timestamp"
+	MetaNonce         = "rpc-nonce"
+	MetaSignature     = "rpc-signature"
+)
+
+// Authenticator authenticates the EndPoints and rpc requests of Router.
+type Authenticator interface {
+	// AuthEndPoint authenticates the new EndPoint(accepted or dialed), it
+	// is closed if an error is returned.
+	AuthEndPoint(ep *EndPoint) error
+	// AuthRequest authenticates rpc request p by ctx.Metadata, it returns
+	// the identity of the caller. The stream is authenticated when it is
+	// opened, p is nil.
+	AuthRequest(ctx *RPCContext, p Payload) (string, error)
+	// Credentials returns the metadata sent with rpc request p to ep.
+	Credentials(ep string, rpc string, p Payload) (Metadata, error)
+}
+
+// AuthorizeRPC returns false if the caller is not allowed to call ctx.RPC.
+type AuthorizeRPC func(ctx *RPCContext) bool
+
+// SetAuthenticator sets the Authenticator of Router, the topic frames of peers
+// are dropped since they are not authenticated. It should be called before
+// Run().
+func (r *Router) SetAuthenticator(a Authenticator) {
+	r.auth = a
+}
+
+// Authorize sets the authorization of rpc, "" is the default of all rpcs. The
+// rejected call fails with ErrPermissionDenied. It should be called before
+// Run().
+func (r *Router) Authorize(rpc string, f AuthorizeRPC) {
+	if f == nil {
+		delete(r.authz, rpc)
+	} else {
+		r.authz[rpc] = f
+	}
+}
+
+// GetName returns the name of EndPoint.
+func (ep *EndPoint) GetName() string {
+	return ep.name
+}
+
+// authEndPoint authenticates the new EndPoint.
+func (r *Router) authEndPoint(ep *EndPoint) error {
+	if r.auth == nil {
+		return nil
+	}
+	return r.auth.AuthEndPoint(ep)
+}
+
+// Authenticate authenticates and authorizes the request p of ctx which is
+// served outside Router(e.g. the local handler of gateway) as the request
+// received by EndPoint, ctx.Identity is set.
+func (r *Router) Authenticate(ctx *RPCContext, p Payload) error {
+	return r.authRequest(ctx, p)
+}
+
+// authRequest authenticates and authorizes the request p of ctx, it runs in
+// the serving goroutine.
+func (r *Router) authRequest(ctx *RPCContext, p Payload) error {
+	if r.auth != nil {
+		if id, err := r.auth.AuthRequest(ctx, p); err != nil {
+			if _, ok := err.(*StatusError); ok {
+				return err
+			}
+			return NewStatusError(STATUS_UNAUTHENTICATED, err.Error())
+		} else {
+			ctx.Identity = id
+		}
+	}
+
+	f, exist := r.authz[ctx.RPC]
+	if !exist {
+		f = r.authz[""]
+	}
+	if f != nil && !f(ctx) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// credentials merges the credentials of call p to md.
+func (r *Router) credentials(ep string, rpc string, p Payload, md Metadata) (Metadata, error) {
+	if r.auth == nil || rpc == "" {
+		return md, nil
+	}
+
+	cred, err := r.auth.Credentials(ep, rpc, p)
+	if err != nil || len(cred) == 0 {
+		return md, err
+	}
+
+	nmd := make(Metadata, len(md)+len(cred))
+	for k, v := range md {
+		nmd[k] = v
+	}
+	for k, v := range cred {
+		nmd[k] = v
+	}
+	return nmd, nil
+}
+
+// TokenAuth authenticates requests by bearer token. The client sends Token if
+// it is not empty, the server verifies the token of requests if Verify is not
+// nil.
+type TokenAuth struct {
+	Token string
+	// Verify returns the identity of token.
+	Verify func(token string) (string, error)
+}
+
+func (a *TokenAuth) AuthEndPoint(ep *EndPoint) error {
+	return nil
+}
+
+func (a *TokenAuth) AuthRequest(ctx *RPCContext, p Payload) (string, error) {
+	if a.Verify == nil {
+		return "", nil
+	}
+
+	v := ctx.Metadata[MetaAuthorization]
+	if !strings.HasPrefix(v, "Bearer ") {
+		return "", ErrUnauthenticated
+	}
+	return a.Verify(v[len("Bearer "):])
+}
+
+func (a *TokenAuth) Credentials(ep string, rpc string, p Payload) (Metadata, error) {
+	if a.Token == "" {
+		return nil, nil
+	}
+	return Metadata{MetaAuthorization: "Bearer " + a.Token}, nil
+}
+
+// HMACAuth authenticates requests by HMAC-SHA256 signature of rpc name,
+// timestamp, nonce and payload digest. The client signs requests by Key if it
+// is not empty, the server verifies the signature by Keys if it is not nil,
+// the nonce can not be reused in MaxSkew.
+type HMACAuth struct {
+	KeyID string
+	Key   []byte
+
+	// Keys returns the key of key id, nil if it does not exist. The key id
+	// is the identity of caller.
+	Keys func(id string) []byte
+	// The max difference of timestamp, 5 minutes if it is 0.
+	MaxSkew time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]time.Time // seen nonce -> expire
+	purged time.Time
+}
+
+// payloadDigest returns the SHA-256 of p. The proto message is encoded
+// deterministically, other payload but []byte is encoded as JSON, it is
+// decoded and encoded again so the generic value has the same digest.
+func payloadDigest(p Payload) (string, error) {
+	if cp, ok := p.(*codecPayload); ok {
+		p = cp.p
+	}
+
+	var b []byte
+	switch t := p.(type) {
+	case nil:
+	case []byte:
+		b = t
+	case proto.Message:
+		var pb proto.Buffer
+		pb.SetDeterministic(true)
+		if err := pb.Marshal(t); err != nil {
+			return "", err
+		}
+		b = pb.Bytes()
+	default:
+		var v interface{}
+		if jb, err := json.Marshal(t); err != nil {
+			return "", err
+		} else if err := json.Unmarshal(jb, &v); err != nil {
+			return "", err
+		} else if b, err = json.Marshal(v); err != nil {
+			return "", err
+		}
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func hmacSign(key []byte, rpc string, ts string, nonce string, digest string) string {
+	h := hmac.New(sha256.New, key)
+	for i, s := range []string{rpc, ts, nonce, digest} {
+		if i > 0 {
+			h.Write([]byte{'\n'})
+		}
+		h.Write([]byte(s))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (a *HMACAuth) AuthEndPoint(ep *EndPoint) error {
+	return nil
+}
+
+func (a *HMACAuth) AuthRequest(ctx *RPCContext, p Payload) (string, error) {
+	if a.Keys == nil {
+		return "", nil
+	}
+
+	id, ts, nonce, sig := ctx.Metadata[MetaKeyID], ctx.Metadata[MetaTimestamp], ctx.Metadata[MetaNonce], ctx.Metadata[MetaSignature]
+	key := a.Keys(id)
+	if key == nil || nonce == "" {
+		return "", ErrUnauthenticated
+	}
+
+	n, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", ErrUnauthenticated
+	}
+	skew := a.MaxSkew
+	if skew == 0 {
+		skew = 5 * time.Minute
+	}
+	if d := time.Since(time.Unix(0, n)); d > skew || d < -skew {
+		return "", ErrUnauthenticated
+	}
+
+	digest, err := payloadDigest(p)
+	if err != nil {
+		return "", ErrUnauthenticated
+	}
+	if !hmac.Equal([]byte(sig), []byte(hmacSign(key, ctx.RPC, ts, nonce, digest))) {
+		return "", ErrUnauthenticated
+	}
+
+	if !a.useNonce(id+"\n"+nonce, time.Unix(0, n).Add(skew)) {
+		// replayed
+		return "", ErrUnauthenticated
+	}
+	return id, nil
+}
+
+// useNonce returns false if nonce is already used, it is remembered until
+// expire.
+func (a *HMACAuth) useNonce(nonce string, expire time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if a.nonces == nil {
+		a.nonces = make(map[string]time.Time)
+	}
+	if now.Sub(a.purged) > time.Minute {
+		for k, t := range a.nonces {
+			if now.After(t) {
+				delete(a.nonces, k)
+			}
+		}
+		a.purged = now
+	}
+
+	if _, exist := a.nonces[nonce]; exist {
+		return false
+	}
+	a.nonces[nonce] = expire
+	return true
+}
+
+func (a *HMACAuth) Credentials(ep string, rpc string, p Payload) (Metadata, error) {
+	if len(a.Key) == 0 {
+		return nil, nil
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	nonce := hex.EncodeToString(b)
+
+	digest, err := payloadDigest(p)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := strconv.FormatInt(time.Now().UnixNano(), 10)
+	return Metadata{MetaKeyID: a.KeyID, MetaTimestamp: ts, MetaNonce: nonce, MetaSignature: hmacSign(a.Key, rpc, ts, nonce, digest)}, nil
+}
@@ -0,0 +1,222 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"testing"
+)
+
+type rejectEndPointAuth struct {
+	HMACAuth
+}
+
+func (a *rejectEndPointAuth) AuthEndPoint(ep *EndPoint) error {
+	if ep.GetName() == "rejected" {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+func TestRouterAuth(t *testing.T) {
+	pf := NewJSONFactory()
+	hf := NewRPCHeaderFactory(pf)
+
+	network := "tcp"
+	address := "localhost:10021"
+
+	server, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	server.SetAuthenticator(&TokenAuth{Verify: func(token string) (string, error) {
+		switch token {
+		case "root-token":
+			return "root", nil
+		case "user-token":
+			return "user", nil
+		}
+		return "", ErrUnauthenticated
+	}})
+	echo := func(ctx *RPCContext, p Payload) (Payload, error) {
+		return p, nil
+	}
+	server.HandleRPC("admin", echo)
+	server.HandleRPC("echo", echo)
+	server.Authorize("admin", func(ctx *RPCContext) bool {
+		return ctx.Identity == "root"
+	})
+	server.Run()
+	defer server.Stop()
+
+	if err := server.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	call := func(token string, rpc string) error {
+		client, err := NewRouter(nil, JSONProcessPayload)
+		if err != nil {
+			t.FailNow()
+		}
+		client.SetAuthenticator(&TokenAuth{Token: token})
+		client.Run()
+		defer client.Stop()
+
+		if err := client.Dial("server", network, address, hf); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		_, err = client.CallWait("server", rpc, nil, 5)
+		return err
+	}
+
+	if err := call("root-token", "admin"); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := call("user-token", "echo"); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := call("user-token", "admin"); StatusOf(err) != STATUS_PERMISSION_DENIED {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := call("", "echo"); StatusOf(err) != STATUS_UNAUTHENTICATED {
+		t.Log(err)
+		t.FailNow()
+	}
+}
+
+func TestRouterHMACAuth(t *testing.T) {
+	pf := NewJSONFactory()
+	hf := NewRPCHeaderFactory(pf)
+
+	network := "tcp"
+	address := "localhost:10022"
+
+	server, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	server.SetAuthenticator(&HMACAuth{Keys: func(id string) []byte {
+		if id == "app" {
+			return []byte("secret")
+		}
+		return nil
+	}})
+	server.HandleRPC("whoami", func(ctx *RPCContext, p Payload) (Payload, error) {
+		if ctx.Identity != "app" {
+			return nil, NewStatusError(STATUS_INTERNAL, ctx.Identity)
+		}
+		return p, nil
+	})
+	server.HandleStream("whoami", func(r *Router, s *Stream) {
+		if p, err := s.Recv(); err == nil {
+			s.Send(p)
+		}
+	})
+	server.Run()
+	defer server.Stop()
+
+	client, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	client.SetAuthenticator(&rejectEndPointAuth{HMACAuth{KeyID: "app", Key: []byte("secret")}})
+	client.Run()
+	defer client.Stop()
+
+	if err := server.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if err := client.Dial("rejected", network, address, hf); err != ErrUnauthenticated {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := client.Dial("server", network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := client.CallWait("server", "whoami", nil, 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	// the payload is signed
+	if _, err := client.CallWait("server", "whoami", &jsonReq{Id: 1, Name: "app"}, 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// the stream is authenticated when it is opened
+	s, err := client.NewStream("server", "whoami", 5)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	s.Send(&jsonReq{Id: 1})
+	s.CloseSend()
+	if _, err := s.Recv(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// replayed or tampered request
+	plain, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	plain.Run()
+	defer plain.Stop()
+
+	if err := plain.Dial("server", network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	a := &HMACAuth{KeyID: "app", Key: []byte("secret")}
+	md, _ := a.Credentials("server", "whoami", &jsonReq{Id: 2})
+	if _, err := plain.CallWait("server", "whoami", WithMetadata(md, &jsonReq{Id: 3}), 5); StatusOf(err) != STATUS_UNAUTHENTICATED {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := plain.CallWait("server", "whoami", WithMetadata(md, &jsonReq{Id: 2}), 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := plain.CallWait("server", "whoami", WithMetadata(md, &jsonReq{Id: 2}), 5); StatusOf(err) != STATUS_UNAUTHENTICATED {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// wrong key
+	bad, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	bad.SetAuthenticator(&HMACAuth{KeyID: "app", Key: []byte("guess")})
+	bad.Run()
+	defer bad.Stop()
+
+	if err := bad.Dial("server", network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := bad.CallWait("server", "whoami", nil, 5); StatusOf(err) != STATUS_UNAUTHENTICATED {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	s, err = bad.NewStream("server", "whoami", 5)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	s.Send(&jsonReq{Id: 1})
+	if _, err := s.Recv(); StatusOf(err) != STATUS_UNAUTHENTICATED {
+		t.Log(err)
+		t.FailNow()
+	}
+}
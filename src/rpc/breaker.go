@@ -0,0 +1,191 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"time"
+)
+
+var (
+	ErrCircuitOpen error = &Error{err: "Circuit breaker is open"}
+)
+
+const (
+	BREAKER_CLOSED = iota
+	BREAKER_OPEN
+	BREAKER_HALF_OPEN
+)
+
+// BreakerPolicy opens the circuit of EndPoint if the error rate of the last
+// Window calls reaches ErrorRate. The open circuit fast-fails calls with
+// ErrCircuitOpen for OpenTimeout, then it turns half-open and allows Probes
+// calls, it is closed if all probes succeed, or open again.
+type BreakerPolicy struct {
+	Window      int
+	ErrorRate   float64
+	OpenTimeout time.Duration
+	Probes      int
+}
+
+// breaker is accessed inside router goroutine.
+type breaker struct {
+	state int
+
+	// closed
+	calls int
+	fails int
+
+	// open
+	opened time.Time
+
+	// half-open
+	probes int // in progress
+	passed int
+}
+
+// SetCircuitBreaker enables the circuit breaker of every EndPoint, nil
+// disables it. It should be called before Run().
+func (r *Router) SetCircuitBreaker(bp *BreakerPolicy) {
+	if bp != nil {
+		if bp.Window <= 0 {
+			bp.Window = 20
+		}
+		if bp.ErrorRate <= 0 {
+			bp.ErrorRate = 0.5
+		}
+		if bp.Probes <= 0 {
+			bp.Probes = 1
+		}
+	}
+	r.bp = bp
+}
+
+// BreakerState returns the circuit state of EndPoint name.
+func (r *Router) BreakerState(name string) int {
+	v, err := r.requestOP(RouterOPBreakerState, name)
+	if err != nil {
+		return BREAKER_CLOSED
+	}
+	return v.(int)
+}
+
+func (r *Router) breakerState(name string) int {
+	if b, exist := r.breakers[name]; exist {
+		return b.state
+	}
+	return BREAKER_CLOSED
+}
+
+// breakerAllow decides whether request m can be sent.
+func (r *Router) breakerAllow(m *routeMsg) bool {
+	m.probe = false
+	if r.bp == nil {
+		return true
+	}
+
+	b, exist := r.breakers[m.ep_name]
+	if !exist {
+		return true
+	}
+
+	switch b.state {
+	case BREAKER_OPEN:
+		if time.Since(b.opened) < r.bp.OpenTimeout {
+			return false
+		}
+		b.state = BREAKER_HALF_OPEN
+		b.probes = 0
+		b.passed = 0
+		fallthrough
+	case BREAKER_HALF_OPEN:
+		if b.probes+b.passed >= r.bp.Probes {
+			return false
+		}
+		b.probes++
+		m.probe = true
+	}
+
+	return true
+}
+
+// breakerFailure tells whether err is caused by the EndPoint.
+func breakerFailure(err error) bool {
+	switch StatusOf(err) {
+	case STATUS_UNAVAILABLE, STATUS_DEADLINE_EXCEEDED, STATUS_INTERNAL:
+		return true
+	}
+	return false
+}
+
+// breakerDone records the result of request m.
+func (r *Router) breakerDone(m *routeMsg, err error) {
+	if r.bp == nil || err == ErrCircuitOpen {
+		return
+	}
+
+	b, exist := r.breakers[m.ep_name]
+	if !exist {
+		if _, exist := r.nmap[m.ep_name]; !exist {
+			// unknown or deleted EndPoint
+			return
+		}
+		b = new(breaker)
+		r.breakers[m.ep_name] = b
+	}
+
+	failed := breakerFailure(err)
+
+	switch b.state {
+	case BREAKER_CLOSED:
+		b.calls++
+		if failed {
+			b.fails++
+		}
+		if b.calls >= r.bp.Window {
+			if b.fails > 0 && float64(b.fails) >= r.bp.ErrorRate*float64(b.calls) {
+				r.breakerOpen(b)
+			}
+			b.calls = 0
+			b.fails = 0
+		}
+	case BREAKER_HALF_OPEN:
+		if !m.probe {
+			return
+		}
+		m.probe = false
+		b.probes--
+		if failed {
+			r.breakerOpen(b)
+		} else if b.passed++; b.passed >= r.bp.Probes {
+			b.state = BREAKER_CLOSED
+			b.calls = 0
+			b.fails = 0
+		}
+	}
+}
+
+// breakerCancel releases the probe of canceled request m.
+func (r *Router) breakerCancel(m *routeMsg) {
+	if !m.probe {
+		return
+	}
+	m.probe = false
+
+	if b, exist := r.breakers[m.ep_name]; exist && b.state == BREAKER_HALF_OPEN {
+		b.probes--
+	}
+}
+
+func (r *Router) breakerOpen(b *breaker) {
+	b.state = BREAKER_OPEN
+	b.opened = time.Now()
+	r.stats.breakerOpen++
+}
+
+// breakerOut records the send error of rpc request.
+func (r *Router) breakerOut(out RoutePayload, err error) {
+	if m := out.(*routeMsg); m.IsRPC() && m.IsRequest() && !m.IsStream() {
+		r.breakerDone(m, err)
+	}
+}
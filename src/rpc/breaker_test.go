@@ -0,0 +1,117 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRouterCircuitBreaker(t *testing.T) {
+	pf := NewJSONFactory()
+	hf := NewRPCHeaderFactory(pf)
+
+	network := "tcp"
+	address := "localhost:10016"
+
+	server, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	server.HandleRPC("fail", func(ctx *RPCContext, p Payload) (Payload, error) {
+		return nil, NewStatusError(STATUS_UNAVAILABLE, "")
+	})
+	server.HandleRPC("ok", func(ctx *RPCContext, p Payload) (Payload, error) {
+		return p, nil
+	})
+	server.Run()
+	defer server.Stop()
+
+	client, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	client.SetCircuitBreaker(&BreakerPolicy{Window: 4, ErrorRate: 0.5, OpenTimeout: 300 * time.Millisecond, Probes: 1})
+	client.Run()
+	defer client.Stop()
+
+	if err := server.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := client.Dial("server", network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := client.CallWait("server", "fail", nil, 5); StatusOf(err) != STATUS_UNAVAILABLE || err == ErrCircuitOpen {
+			t.Log(i, err)
+			t.FailNow()
+		}
+	}
+	if s := client.BreakerState("server"); s != BREAKER_OPEN {
+		t.Log(s)
+		t.FailNow()
+	}
+
+	// fast fail
+	if _, err := client.CallWait("server", "ok", nil, 5); err != ErrCircuitOpen {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// half-open, the probe fails
+	time.Sleep(400 * time.Millisecond)
+	if _, err := client.CallWait("server", "fail", nil, 5); err == ErrCircuitOpen {
+		t.Log(err)
+		t.FailNow()
+	}
+	if s := client.BreakerState("server"); s != BREAKER_OPEN {
+		t.Log(s)
+		t.FailNow()
+	}
+
+	// half-open, the probe succeeds
+	time.Sleep(400 * time.Millisecond)
+	if _, err := client.CallWait("server", "ok", nil, 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if s := client.BreakerState("server"); s != BREAKER_CLOSED {
+		t.Log(s)
+		t.FailNow()
+	}
+}
+
+func TestBreakerPolicyDefault(t *testing.T) {
+	r, err := NewRouter(nil, nil)
+	if err != nil {
+		t.FailNow()
+	}
+	r.SetCircuitBreaker(&BreakerPolicy{Window: 2})
+
+	// unknown EndPoint
+	r.breakerDone(&routeMsg{ep_name: "none"}, ErrCallTimeout)
+	if len(r.breakers) != 0 {
+		t.Log(r.breakers)
+		t.FailNow()
+	}
+
+	r.nmap["server"] = nil
+	for i := 0; i < 4; i++ {
+		r.breakerDone(&routeMsg{ep_name: "server"}, nil)
+	}
+	if s := r.breakerState("server"); s != BREAKER_CLOSED {
+		t.Log(s)
+		t.FailNow()
+	}
+
+	// deleted EndPoint
+	r.delEndPoint("server")
+	if len(r.breakers) != 0 {
+		t.Log(r.breakers)
+		t.FailNow()
+	}
+}
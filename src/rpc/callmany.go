@@ -0,0 +1,200 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	ErrCallManyPending error = &Error{err: "CallMany reply is pending"}
+	ErrCallManyFailed  error = &Error{err: "CallMany policy is not satisfied"}
+	ErrCallManyEmpty   error = &Error{err: "CallMany without EndPoint"}
+)
+
+// CallPolicy decides when CallMany completes.
+type CallPolicy int
+
+const (
+	// All EndPoints reply or fail.
+	CallAll CallPolicy = iota
+	// The first reply.
+	CallFirst
+	// The majority of EndPoints reply.
+	CallQuorum
+)
+
+// CallResult is the reply or the error of one EndPoint.
+type CallResult struct {
+	EP    string
+	Reply Payload
+	Err   error
+
+	mc *multiCall
+}
+
+type multiCall struct {
+	mu sync.Mutex
+
+	policy  CallPolicy
+	results []CallResult
+	msgs    []*routeMsg
+
+	replied int
+	failed  int
+
+	ok   bool
+	done bool
+	ch   chan struct{}
+}
+
+// complete is called with mc.mu held.
+func (mc *multiCall) complete() {
+	n := len(mc.results)
+
+	switch mc.policy {
+	case CallAll:
+		mc.ok = mc.failed == 0
+		mc.done = mc.replied+mc.failed == n
+	case CallFirst:
+		mc.ok = mc.replied > 0
+		mc.done = mc.ok || mc.failed == n
+	case CallQuorum:
+		q := n/2 + 1
+		mc.ok = mc.replied >= q
+		mc.done = mc.ok || n-mc.failed < q
+	}
+
+	if mc.done {
+		close(mc.ch)
+	}
+}
+
+// multi_done collects the reply of one EndPoint.
+func multi_done(p Payload, arg RPCCallback_arg, err error) {
+	res := arg.(*CallResult)
+	mc := res.mc
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if res.Err != ErrCallManyPending {
+		// TODO: should not happen
+		return
+	}
+
+	res.Reply = p
+	res.Err = err
+	if err != nil {
+		mc.failed++
+	} else {
+		mc.replied++
+	}
+
+	if !mc.done {
+		mc.complete()
+	}
+}
+
+// CallMany calls rpc of all eps with the same request inside router
+// goroutine, it returns when policy is satisfied or all calls finished, the
+// deadline is n seconds(0 means 5 minutes) for all calls. The result of
+// unfinished call is ErrCallManyPending.
+func (r *Router) CallMany(eps []string, rpc string, p Payload, policy CallPolicy, n time.Duration) ([]CallResult, error) {
+	if len(eps) == 0 {
+		return nil, ErrCallManyEmpty
+	}
+
+	if n < 0 {
+		return nil, ErrCallTimeout
+	} else if n == 0 {
+		n = 5 * time.Minute
+	} else {
+		n = n * time.Second
+	}
+
+	to := time.Now().Add(n)
+
+	mc := new(multiCall)
+	mc.policy = policy
+	mc.results = make([]CallResult, len(eps))
+	mc.msgs = make([]*routeMsg, len(eps))
+	mc.ch = make(chan struct{})
+
+	for i, ep := range eps {
+		res := &mc.results[i]
+		res.EP = ep
+		res.Err = ErrCallManyPending
+		res.mc = mc
+
+		md, err := r.credentials(ep, rpc, p, nil)
+		if err != nil {
+			for _, m := range mc.msgs[:i] {
+				m.Recycle()
+			}
+			return nil, err
+		}
+
+		var out *routeMsg
+		if v := r.clientOutMsgs.Get(); v == nil {
+			for _, m := range mc.msgs[:i] {
+				m.Recycle()
+			}
+			return nil, ErrOPRouterStopped
+		} else {
+			out = v.(*routeMsg)
+		}
+
+		out.Reset()
+		out.ep_name = ep
+		out.rpc = rpc
+		out.is_rpc = true
+		out.is_request = true
+		out.md = md
+		out.prio = r.priority(rpc, md)
+
+		out.p = p
+
+		out.cb = multi_done
+		out.arg = res
+		out.to = to
+
+		out.r = r
+
+		mc.msgs[i] = out
+	}
+
+	if _, err := r.requestOP(RouterOPCallMany, mc); err != nil {
+		for _, m := range mc.msgs {
+			m.Recycle()
+		}
+		return nil, err
+	}
+
+	<-mc.ch
+
+	mc.mu.Lock()
+	results := make([]CallResult, len(mc.results))
+	copy(results, mc.results)
+	ok := mc.ok
+	mc.mu.Unlock()
+
+	for i := range results {
+		results[i].mc = nil
+	}
+
+	if !ok {
+		return results, ErrCallManyFailed
+	}
+	return results, nil
+}
+
+func (r *Router) callMany(mc *multiCall) {
+	for _, m := range mc.msgs {
+		r.ProcessOut(m)
+	}
+	// owned by router now
+	mc.msgs = nil
+}
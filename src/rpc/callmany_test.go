@@ -0,0 +1,77 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"testing"
+)
+
+func TestRouterCallMany(t *testing.T) {
+	pf := NewJSONFactory()
+	pf.Register("rpc", func() Payload { return new(jsonReq) }, func() Payload { return new(jsonResp) })
+	hf := NewRPCHeaderFactory(pf)
+
+	network := "tcp"
+	address := "localhost:10011"
+
+	r, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	r.Run()
+	defer r.Stop()
+
+	if err := r.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := r.Dial(name, network, address, hf); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+
+	cases := []struct {
+		eps    []string
+		policy CallPolicy
+		ok     bool
+	}{
+		{[]string{"a", "b", "c"}, CallAll, true},
+		{[]string{"a", "b", "none"}, CallAll, false},
+		{[]string{"none", "a"}, CallFirst, true},
+		{[]string{"none", "none2"}, CallFirst, false},
+		{[]string{"a", "b", "none"}, CallQuorum, true},
+		{[]string{"a", "none", "none2"}, CallQuorum, false},
+	}
+
+	for i, c := range cases {
+		results, err := r.CallMany(c.eps, "rpc", &jsonReq{Id: uint64(i)}, c.policy, 5)
+		if (err == nil) != c.ok {
+			t.Log(i, err)
+			t.FailNow()
+		}
+		if len(results) != len(c.eps) {
+			t.Log(i, results)
+			t.FailNow()
+		}
+		for j, res := range results {
+			if res.EP != c.eps[j] {
+				t.Log(i, res)
+				t.FailNow()
+			} else if res.Err == nil && res.Reply.(*jsonResp).Id != uint64(i) {
+				t.Log(i, res)
+				t.FailNow()
+			} else if c.policy == CallAll && res.Err == ErrCallManyPending {
+				t.Log(i, res)
+				t.FailNow()
+			}
+		}
+	}
+
+	if _, err := r.CallMany(nil, "rpc", &jsonReq{}, CallAll, 5); err != ErrCallManyEmpty {
+		t.Log(err)
+		t.FailNow()
+	}
+}
@@ -4,12 +4,14 @@
 package rpc
 
 import (
+	"context"
 	"time"
 )
 
 // invisible to outside, reduce the GC pressure.
 type waiter struct {
-	ch chan Payload
+	ch  chan Payload
+	err error
 
 	owner *ResourceManager
 
@@ -35,7 +37,7 @@ func call_done(p Payload, arg RPCCallback_arg, err error) {
 	if w, ok := arg.(*waiter); !ok {
 		panic("call_done")
 	} else if err != nil {
-		// TODO: error ?
+		w.err = err
 		w.ch <- nil
 	} else {
 		w.ch <- p
@@ -66,10 +68,69 @@ func (r *Router) CallWait(ep string, rpc string, p Payload, n time.Duration) (Pa
 	r.call(ep, rpc, p, call_done, w, to)
 	// wait result, rpc must returns something.
 	result := <-w.ch
+	err := w.err
+	w.err = nil
 
 	w.Recycle()
 
-	return result, nil
+	return result, err
+}
+
+type ctxResult struct {
+	p   Payload
+	err error
+}
+
+// ctxCall is the call of CallContext, it is canceled when ctx is done.
+type ctxCall struct {
+	ch chan ctxResult
+
+	// accessed inside router goroutine
+	id       uint64
+	canceled bool
+}
+
+func ctx_call_done(p Payload, arg RPCCallback_arg, err error) {
+	arg.(*ctxCall).ch <- ctxResult{p: p, err: err}
+}
+
+// CallContext is CallWait which times out at the deadline of ctx(5 minutes if
+// none), the call is canceled and ctx.Err() is returned when ctx is done.
+func (r *Router) CallContext(ctx context.Context, ep string, rpc string, p Payload) (Payload, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	to, ok := ctx.Deadline()
+	if !ok {
+		to = time.Now().Add(5 * time.Minute)
+	}
+
+	c := &ctxCall{ch: make(chan ctxResult, 1)}
+	r.call(ep, rpc, p, ctx_call_done, c, to)
+
+	select {
+	case res := <-c.ch:
+		return res.p, res.err
+	case <-ctx.Done():
+		r.requestOP(RouterOPCancelCall, c)
+		return nil, ctx.Err()
+	}
+}
+
+// cancelCall drops the call of CallContext, the request which is not sent yet
+// is dropped by rpcRequestOut.
+func (r *Router) cancelCall(c *ctxCall) {
+	c.canceled = true
+
+	if out, exist := r.calls[c.id]; exist && out.(*routeMsg).arg == c {
+		m := out.(*routeMsg)
+		delete(r.calls, c.id)
+		r.tt.Del(m.tid)
+		r.breakerCancel(m)
+		r.limitRelease(m)
+		m.Recycle()
+	}
 }
 
 // Call async
@@ -87,6 +148,28 @@ func (r *Router) Call(ep string, rpc string, p Payload, cb RPCCallback_func, arg
 }
 
 func (rm *routeMsg) Return(r *Router, reply RouteRPCPayload) {
-	go rm.cb(reply.GetPayload(), rm.arg, nil)
+	if err := reply.(*routeMsg).statusError(); err != nil {
+		go rm.cb(nil, rm.arg, err)
+	} else {
+		go rm.cb(reply.GetPayload(), rm.arg, nil)
+	}
 	rm.Recycle()
 }
+
+// setStatusError sets the status of err(see StatusOf).
+func (rm *routeMsg) setStatusError(err error) {
+	rm.status = StatusOf(err)
+	if se, ok := err.(*StatusError); ok {
+		rm.status_msg = se.msg
+	} else {
+		rm.status_msg = err.Error()
+	}
+}
+
+// statusError returns the error of reply status.
+func (rm *routeMsg) statusError() error {
+	if rm.status == STATUS_OK {
+		return nil
+	}
+	return &StatusError{code: rm.status, msg: rm.status_msg}
+}
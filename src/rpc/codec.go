@@ -0,0 +1,38 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import ()
+
+var (
+	ErrCodecUnknown error = &Error{err: "unknown codec"}
+)
+
+// Codec id carried in the frame header, the receiver chooses the decoder per
+// frame. CodecDefault is the payload factory passed to the header factory.
+const (
+	CodecDefault = iota
+	CodecProtobuf
+	CodecJSON
+	CodecMsgpack
+	CodecRaw
+)
+
+type codecPayload struct {
+	codec uint8
+	p     Payload
+}
+
+// WithCodec marks p to be encoded by the codec registered as id.
+func WithCodec(id uint8, p Payload) Payload {
+	return &codecPayload{codec: id, p: p}
+}
+
+// unwrapCodec returns the codec id and the real payload.
+func unwrapCodec(p Payload) (uint8, Payload) {
+	if cp, ok := p.(*codecPayload); ok {
+		return cp.codec, cp.p
+	}
+	return CodecDefault, p
+}
@@ -0,0 +1,80 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"bytes"
+	"github.com/golang/protobuf/proto"
+	pbt "rpc/pb_test"
+	"testing"
+)
+
+func MixedProcessPayload(r *Router, name string, p Payload) Payload {
+	switch t := p.(type) {
+	case *pbt.ResourceReq:
+		return ServiceProcessPayload(r, name, p)
+	case map[string]interface{}:
+		return WithCodec(CodecJSON, t)
+	case []byte:
+		return WithCodec(CodecRaw, t)
+	default:
+		panic("MixedProcessPayload receieve wrong info")
+	}
+}
+
+func TestRouterMixedCodec(t *testing.T) {
+	r, err := NewRouter(nil, MixedProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+
+	pf := NewProtobufFactory()
+	pf.Register("rpc", func() Payload { return pbt.NewResourceReq() }, func() Payload { return pbt.NewResourceResp() })
+	hf := NewRPCHeaderFactory(pf)
+	hf.RegisterCodec(CodecJSON, NewJSONFactory())
+	hf.RegisterCodec(CodecRaw, NewRawFactory())
+
+	name := "scheduler"
+	network := "tcp"
+	address := "localhost:10005"
+
+	r.Run()
+	defer r.Stop()
+
+	if err := r.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := r.Dial(name, network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	req := pbt.NewResourceReq()
+	req.Id = proto.Uint64(1)
+	if p, err := r.CallWait(name, "rpc", req, 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if resp, ok := p.(*pbt.ResourceResp); !ok || resp.GetId() != 1 {
+		t.Log(p)
+		t.FailNow()
+	}
+
+	if p, err := r.CallWait(name, "json", WithCodec(CodecJSON, map[string]interface{}{"id": 2}), 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if m, ok := p.(map[string]interface{}); !ok || m["id"].(float64) != 2 {
+		t.Log(p)
+		t.FailNow()
+	}
+
+	raw := []byte("raw bytes")
+	if p, err := r.CallWait(name, "raw", WithCodec(CodecRaw, raw), 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if b, ok := p.([]byte); !ok || !bytes.Equal(b, raw) {
+		t.Log(p)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import ()
+
+// Frame flags shared by all the header flavors, the low bits of flags are
+// used by the flavor itself.
+const (
+	// The payload is a chunk of a fragmented message, rpcid is the fragment
+	// id. The chunks of all CONTINUATION frames with the same fragment id
+	// are the original frame(header and payload).
+	FRAME_CONTINUATION = 1 << 15
+	// The last CONTINUATION frame.
+	FRAME_END = 1 << 14
+	// The rpc message is STREAM_DATA of stream rpcid.
+	FRAME_STREAM_DATA = 1 << 13
+	// STREAM_END, the sender will not send STREAM_DATA any more.
+	FRAME_STREAM_END = 1 << 12
+	// Control frame, subscribe the topic(rpc name) of the peer.
+	FRAME_SUBSCRIBE = 1 << 11
+	// Control frame, unsubscribe the topic(rpc name) of the peer.
+	FRAME_UNSUBSCRIBE = 1 << 10
+	// The message is published to topic(rpc name).
+	FRAME_PUBLISH = 1 << 9
+	// 2 bits, the priority of the message.
+	FRAME_PRIORITY = 1<<7 | 1<<6
+
+	frame_topic_mask     = FRAME_SUBSCRIBE | FRAME_UNSUBSCRIBE | FRAME_PUBLISH
+	frame_priority_shift = 6
+)
+
+// FrameBuffer is implemented by MsgBuffer which supports CONTINUATION frames.
+type FrameBuffer interface {
+	// MarshalContinuation writes the header of CONTINUATION frame, l is
+	// the chunk length.
+	MarshalContinuation(b []byte, id uint64, l uint32, end bool) error
+	// GetContinuation returns fragment id, is CONTINUATION and is the last
+	// one after UnmarshalHeader().
+	GetContinuation() (uint64, bool, bool)
+}
+
+type fragment struct {
+	id  uint64
+	b   []byte
+	off int
+}
+
+func (hb *msgHeaderBuffer) MarshalContinuation(b []byte, id uint64, l uint32, end bool) error {
+	hb.Reset()
+	hb.h.rpcid = id
+	hb.h.flags = FRAME_CONTINUATION
+	if end {
+		hb.h.flags |= FRAME_END
+	}
+	return hb.MarshalHeader(b, nil, l)
+}
+
+func (hb *msgHeaderBuffer) GetContinuation() (uint64, bool, bool) {
+	return hb.h.rpcid, (hb.h.flags & FRAME_CONTINUATION) != 0, (hb.h.flags & FRAME_END) != 0
+}
+
+func (hb *rpcHeaderBuffer) MarshalContinuation(b []byte, id uint64, l uint32, end bool) error {
+	hb.Reset()
+	hb.h.rpcid = id
+	hb.h.flags = FRAME_CONTINUATION
+	if end {
+		hb.h.flags |= FRAME_END
+	}
+	return hb.MarshalHeader(b, nil, l)
+}
+
+func (hb *rpcHeaderBuffer) GetContinuation() (uint64, bool, bool) {
+	return hb.h.rpcid, (hb.h.flags & FRAME_CONTINUATION) != 0, (hb.h.flags & FRAME_END) != 0
+}
+
+// marshalFrameFlags returns the shared frame flags of rpc payload p.
+func marshalFrameFlags(p Payload) uint16 {
+	var flags uint16
+
+	if si, ok := p.(StreamInfo); ok && si.IsStream() {
+		flags |= FRAME_STREAM_DATA
+		if si.IsStreamEnd() {
+			flags |= FRAME_STREAM_END
+		}
+	}
+
+	if ti, ok := p.(TopicInfo); ok {
+		flags |= ti.GetTopicOP() & frame_topic_mask
+	}
+
+	if pi, ok := p.(PriorityInfo); ok {
+		flags |= (uint16(pi.GetPriority()) << frame_priority_shift) & FRAME_PRIORITY
+	}
+
+	return flags
+}
+
+// unmarshalFrameFlags sets the shared frame flags to rpc payload p.
+func unmarshalFrameFlags(flags uint16, p Payload) {
+	if si, ok := p.(StreamInfo); ok && (flags&FRAME_STREAM_DATA) != 0 {
+		si.SetIsStream()
+		if (flags & FRAME_STREAM_END) != 0 {
+			si.SetIsStreamEnd()
+		}
+	}
+
+	if ti, ok := p.(TopicInfo); ok && (flags&frame_topic_mask) != 0 {
+		ti.SetTopicOP(flags & frame_topic_mask)
+	}
+
+	if pi, ok := p.(PriorityInfo); ok {
+		pi.SetPriority(Priority((flags & FRAME_PRIORITY) >> frame_priority_shift))
+	}
+}
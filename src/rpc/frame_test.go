@@ -0,0 +1,173 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+func RawProcessPayload(r *Router, name string, p Payload) Payload {
+	return p
+}
+
+type fragmentCall struct {
+	wg  *sync.WaitGroup
+	req []byte
+	err error
+}
+
+func FragmentProcessReponse(p Payload, arg RPCCallback_arg, err error) {
+	fc := arg.(*fragmentCall)
+	if err != nil {
+		fc.err = err
+	} else if b, ok := p.([]byte); !ok || !bytes.Equal(b, fc.req) {
+		fc.err = &Error{err: "reply mismatch"}
+	}
+	fc.wg.Done()
+}
+
+func TestRouterFragment(t *testing.T) {
+	r, err := NewRouter(nil, RawProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	r.SetFragmentSize(16 * 1024)
+
+	hf := NewRPCHeaderFactory(NewRawFactory())
+
+	name := "scheduler"
+	network := "tcp"
+	address := "localhost:10006"
+
+	r.Run()
+	defer r.Stop()
+
+	if err := r.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := r.Dial(name, network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	var wg sync.WaitGroup
+	var calls []*fragmentCall
+	for i := 0; i < 64; i++ {
+		n := 10
+		if i%8 == 0 {
+			// larger than the Reader/Writer buffer
+			n = 1024*1024 + i
+		}
+		req := make([]byte, n)
+		for j := range req {
+			req[j] = byte(i + j)
+		}
+
+		fc := &fragmentCall{wg: &wg, req: req}
+		calls = append(calls, fc)
+
+		wg.Add(1)
+		r.Call(name, "rpc", req, FragmentProcessReponse, fc, 5)
+	}
+	wg.Wait()
+
+	for i, fc := range calls {
+		if fc.err != nil {
+			t.Log(i, ":", fc.err)
+			t.Fail()
+		}
+	}
+}
+
+func continuationFrames(ids []uint64, l int) []byte {
+	mb := NewRPCHeaderFactory(NewRawFactory()).NewBuffer()
+	chunk := make([]byte, l)
+
+	var b []byte
+	for _, id := range ids {
+		hb := make([]byte, mb.GetHdrLen())
+		mb.(FrameBuffer).MarshalContinuation(hb, id, uint32(l), false)
+		b = append(b, hb...)
+		b = append(b, chunk...)
+	}
+	return b
+}
+
+func TestReaderFragmentLimit(t *testing.T) {
+	var ids []uint64
+	for i := 0; i <= maxFragments; i++ {
+		ids = append(ids, uint64(i+1))
+	}
+	b := continuationFrames(ids, 1)
+	r := NewReader(ioutil.NopCloser(bytes.NewReader(b)), make(chanPayload, 1), NewRPCHeaderFactory(NewRawFactory()).NewBuffer(), nil)
+	if _, err := r.LoopOnce(nil); err != ErrTooManyFragments {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	ids = nil
+	for i := 0; i <= 1024*1024/(64*1024); i++ {
+		ids = append(ids, 1)
+	}
+	b = continuationFrames(ids, 64*1024)
+	r = NewReader(ioutil.NopCloser(bytes.NewReader(b)), make(chanPayload, 1), NewRPCHeaderFactory(NewRawFactory()).NewBuffer(), nil)
+	r.SetMaxMessageSize(1024 * 1024)
+	if _, err := r.LoopOnce(nil); err != ErrFragmentTooLarge {
+		t.Log(err)
+		t.FailNow()
+	}
+}
+
+func TestRouterMaxMessageSize(t *testing.T) {
+	r, err := NewRouter(nil, RawProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	r.SetFragmentSize(16 * 1024)
+	r.SetMaxMessageSize(1024 * 1024)
+	r.HandleRPC("large", func(ctx *RPCContext, p Payload) (Payload, error) {
+		return make([]byte, 2*1024*1024), nil
+	})
+
+	hf := NewRPCHeaderFactory(NewRawFactory())
+
+	name := "scheduler"
+	network := "tcp"
+	address := "localhost:10030"
+
+	r.Run()
+	defer r.Stop()
+
+	if err := r.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := r.Dial(name, network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// the large request and reply fail, the EndPoint is still usable.
+	if _, err := r.CallWait(name, "rpc", make([]byte, 2*1024*1024), 5); StatusOf(err) != STATUS_RESOURCE_EXHAUSTED {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := r.CallWait(name, "large", []byte("hello"), 5); StatusOf(err) != STATUS_RESOURCE_EXHAUSTED {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	req := make([]byte, 512*1024)
+	if p, err := r.CallWait(name, "rpc", req, 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if b, ok := p.([]byte); !ok || !bytes.Equal(b, req) {
+		t.Log(len(b))
+		t.FailNow()
+	}
+}
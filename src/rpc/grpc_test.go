@@ -0,0 +1,177 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const grpcTestMethod = "test.Echo/Say"
+
+func newGRPCTestFactory() RPCPayloadFactory {
+	pf := NewProtobufFactory()
+	pf.Register(grpcTestMethod, func() Payload { return new(wrapperspb.StringValue) }, func() Payload { return new(wrapperspb.StringValue) })
+	return pf
+}
+
+// grpc-go client -> Router
+func TestRouterServeGRPC(t *testing.T) {
+	address := "localhost:10026"
+
+	server, err := NewRouter(nil, nil)
+	if err != nil {
+		t.FailNow()
+	}
+	server.HandleRPC(grpcTestMethod, func(ctx *RPCContext, p Payload) (Payload, error) {
+		switch v := p.(*wrapperspb.StringValue).GetValue(); v {
+		case "fail":
+			return nil, NewStatusError(STATUS_NOT_FOUND, "not found: 100%\n")
+		case "slow":
+			time.Sleep(500 * time.Millisecond)
+			return wrapperspb.String(v), nil
+		default:
+			return wrapperspb.String(v + ctx.Metadata["key"]), nil
+		}
+	})
+	server.Run()
+	defer server.Stop()
+
+	if err := server.ListenAndServeGRPC("client", "tcp", address, newGRPCTestFactory()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	cc, err := grpc.NewClient("passthrough:///"+address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer cc.Close()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "key", "value")
+	out := new(wrapperspb.StringValue)
+	if err := cc.Invoke(ctx, "/"+grpcTestMethod, wrapperspb.String("hello "), out); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if out.GetValue() != "hello value" {
+		t.Log(out)
+		t.FailNow()
+	}
+
+	// status
+	if err := cc.Invoke(ctx, "/"+grpcTestMethod, wrapperspb.String("fail"), out); status.Code(err) != codes.NotFound {
+		t.Log(err)
+		t.FailNow()
+	} else if s, _ := status.FromError(err); s.Message() != "not found: 100%\n" {
+		t.Log(s.Message())
+		t.FailNow()
+	}
+
+	// grpc-timeout
+	tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := cc.Invoke(tctx, "/"+grpcTestMethod, wrapperspb.String("slow"), out); status.Code(err) != codes.DeadlineExceeded {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// unknown method
+	if err := cc.Invoke(ctx, "/test.Echo/None", wrapperspb.String("x"), out); status.Code(err) != codes.Unimplemented {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// the request body is limited to 4MB by default
+	if err := cc.Invoke(ctx, "/"+grpcTestMethod, wrapperspb.String(strings.Repeat("x", 256*1024)), out); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if len(out.GetValue()) != 256*1024+len("value") {
+		t.Log(len(out.GetValue()))
+		t.FailNow()
+	}
+	if err := cc.Invoke(ctx, "/"+grpcTestMethod, wrapperspb.String(strings.Repeat("x", 5*1024*1024)), out); status.Code(err) != codes.ResourceExhausted {
+		t.Log(err)
+		t.FailNow()
+	}
+}
+
+// Router -> grpc-go server
+func TestRouterDialGRPC(t *testing.T) {
+	address := "localhost:10027"
+
+	sd := &grpc.ServiceDesc{
+		ServiceName: "test.Echo",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Say",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := new(wrapperspb.StringValue)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if in.GetValue() == "fail" {
+					return nil, status.Error(codes.PermissionDenied, "denied: 100%")
+				}
+				md, _ := metadata.FromIncomingContext(ctx)
+				return wrapperspb.String(in.GetValue() + md.Get("key")[0]), nil
+			},
+		}},
+	}
+
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	gs := grpc.NewServer()
+	gs.RegisterService(sd, struct{}{})
+	go gs.Serve(l)
+	defer gs.Stop()
+
+	client, err := NewRouter(nil, nil)
+	if err != nil {
+		t.FailNow()
+	}
+	client.Run()
+	defer client.Stop()
+
+	if err := client.DialGRPC("server", "tcp", address, newGRPCTestFactory()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	md := Metadata{"key": "value"}
+	if p, err := client.CallWait("server", grpcTestMethod, WithMetadata(md, wrapperspb.String("hello ")), 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if v, ok := p.(*wrapperspb.StringValue); !ok || v.GetValue() != "hello value" {
+		t.Log(p)
+		t.FailNow()
+	}
+
+	// status
+	if _, err := client.CallWait("server", grpcTestMethod, WithMetadata(md, wrapperspb.String("fail")), 5); StatusOf(err) != STATUS_PERMISSION_DENIED {
+		t.Log(err)
+		t.FailNow()
+	} else if se := err.(*StatusError); se.Message() != "denied: 100%" {
+		t.Log(se.Message())
+		t.FailNow()
+	}
+
+	// unknown method
+	if _, err := client.CallWait("server", "test.Echo/None", WithMetadata(md, wrapperspb.String("x")), 5); StatusOf(err) != STATUS_UNIMPLEMENTED {
+		t.Log(err)
+		t.FailNow()
+	}
+}
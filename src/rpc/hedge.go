@@ -0,0 +1,289 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// HedgePolicy sends duplicate requests to other EndPoints of the group if the
+// reply is late, the first reply wins and the rest are canceled.
+type HedgePolicy struct {
+	// Delay before hedging, the resolution is the tick of TimeoutTracker.
+	Delay time.Duration
+	// Use the percentile(e.g. 0.95) of observed latency as delay once
+	// there are enough samples.
+	Percentile float64
+	// Max duplicate requests of one call, 1 if it is 0.
+	MaxHedges int
+	// Max ratio of duplicate requests to calls, e.g. 0.1 for 10% extra load.
+	// 0 means unlimited.
+	MaxRatio float64
+}
+
+const hedgeSamples = 128
+
+// hedgeState is accessed inside router goroutine.
+type hedgeState struct {
+	hp *HedgePolicy
+
+	calls  uint64
+	hedges uint64
+
+	lat []time.Duration
+	pos int
+}
+
+func (hs *hedgeState) observe(d time.Duration) {
+	if len(hs.lat) < hedgeSamples {
+		hs.lat = append(hs.lat, d)
+	} else {
+		hs.lat[hs.pos] = d
+		hs.pos = (hs.pos + 1) % hedgeSamples
+	}
+}
+
+func (hs *hedgeState) delay() time.Duration {
+	hp := hs.hp
+	if hp.Percentile <= 0 || len(hs.lat) < hedgeSamples/4 {
+		return hp.Delay
+	}
+
+	lat := make([]time.Duration, len(hs.lat))
+	copy(lat, hs.lat)
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+
+	i := int(float64(len(lat)) * hp.Percentile)
+	if i >= len(lat) {
+		i = len(lat) - 1
+	}
+	return lat[i]
+}
+
+func (hs *hedgeState) allow() bool {
+	if hs.hp.MaxRatio <= 0 {
+		return true
+	}
+	return float64(hs.hedges+1) <= hs.hp.MaxRatio*float64(hs.calls)
+}
+
+// hedgeCall is one call to a group.
+type hedgeCall struct {
+	r  *Router
+	hs *hedgeState
+
+	// the original request
+	rpc   string
+	p     Payload
+	md    Metadata
+	to    time.Time
+	cb    RPCCallback_func
+	arg   RPCCallback_arg
+	group []string
+	ep    string // the first EndPoint
+
+	// accessed inside router goroutine
+	at  time.Time
+	tid TrackID
+	ids []uint64
+
+	mu      sync.Mutex
+	sent    int
+	failed  int
+	pending bool // hedge timer
+	done    bool
+	err     error
+}
+
+// SetGroup names EndPoints eps as group name, the request to group is sent to
+// one of eps by round robin. It should be called before Run().
+func (r *Router) SetGroup(name string, eps []string) {
+	r.groups[name] = eps
+}
+
+// SetHedgePolicy enables hedging for rpc called to groups, nil disables it.
+// It should be called before Run().
+func (r *Router) SetHedgePolicy(rpc string, hp *HedgePolicy) {
+	if hp == nil {
+		delete(r.hedges, rpc)
+	} else {
+		r.hedges[rpc] = &hedgeState{hp: hp}
+	}
+}
+
+// groupOut chooses the EndPoint of group for m, it may start hedging.
+func (r *Router) groupOut(m *routeMsg, group []string) {
+	if len(group) == 0 {
+		return
+	}
+
+	r.groupNext++
+	m.ep_name = group[r.groupNext%uint64(len(group))]
+
+	hs, exist := r.hedges[m.rpc]
+	if !exist || !m.IsRequest() || len(group) < 2 {
+		return
+	}
+	hs.calls++
+
+	hc := new(hedgeCall)
+	hc.r = r
+	hc.hs = hs
+	hc.rpc = m.rpc
+	hc.p = m.p
+	hc.md = m.md
+	hc.to = m.to
+	hc.cb = m.cb
+	hc.arg = m.arg
+	hc.group = group
+	hc.ep = m.ep_name
+
+	hc.sent = 1
+	hc.at = time.Now().Add(hs.delay())
+	if hc.at.Before(hc.to) {
+		if tid, err := r.tt.Add(hc); err == nil {
+			hc.tid = tid
+			hc.pending = true
+		}
+	}
+
+	m.hedge = hc
+	m.cb = hedge_done
+	m.arg = hc
+}
+
+func (hc *hedgeCall) When() time.Time {
+	return hc.at
+}
+
+// Timeout sends the duplicate requests.
+func (hc *hedgeCall) Timeout(now time.Time) {
+	r := hc.r
+	hs := hc.hs
+	hc.tid = InvalidTrackID
+
+	n := hs.hp.MaxHedges
+	if n <= 0 {
+		n = 1
+	}
+
+	hc.mu.Lock()
+	for _, ep := range hc.group {
+		if hc.done || n == 0 || !hs.allow() {
+			break
+		} else if ep == hc.ep {
+			continue
+		}
+
+		// the credentials are signed for each EndPoint.
+		md, err := r.credentials(ep, hc.rpc, hc.p, hc.md)
+		if err != nil {
+			break
+		}
+
+		// never wait inside router goroutine
+		var out *routeMsg
+		if v := r.clientOutMsgs.TryGet(); v == nil {
+			break
+		} else {
+			out = v.(*routeMsg)
+		}
+
+		out.Reset()
+		out.ep_name = ep
+		out.rpc = hc.rpc
+		out.is_rpc = true
+		out.is_request = true
+		out.md = md
+		out.prio = r.priority(hc.rpc, md)
+		out.p = hc.p
+		out.cb = hedge_done
+		out.arg = hc
+		out.to = hc.to
+		out.r = r
+		out.hedge = hc
+
+		select {
+		case r.outQueue(out) <- out:
+		default:
+			panic("routeMsg leaks?!")
+		}
+
+		hc.sent++
+		hs.hedges++
+		r.stats.rpcHedge++
+		n--
+	}
+	hc.pending = false
+
+	// all failed before hedging
+	var err error
+	if !hc.done && hc.failed == hc.sent {
+		hc.done = true
+		err = hc.err
+	}
+	hc.mu.Unlock()
+
+	if err != nil {
+		go hc.cb(nil, hc.arg, err)
+	}
+}
+
+// hedge_done returns the first reply, or the last error if all failed.
+func hedge_done(p Payload, arg RPCCallback_arg, err error) {
+	hc := arg.(*hedgeCall)
+
+	hc.mu.Lock()
+	if hc.done {
+		hc.mu.Unlock()
+		return
+	}
+
+	if err != nil {
+		hc.failed++
+		hc.err = err
+		if hc.failed < hc.sent || hc.pending {
+			hc.mu.Unlock()
+			return
+		}
+	}
+	hc.done = true
+	hc.mu.Unlock()
+
+	hc.cb(p, hc.arg, err)
+
+	// cancel the rest
+	hc.r.requestOP(RouterOPCancelHedge, hc)
+}
+
+// hedgeOut records the request of hc after it gets rpc id.
+func (r *Router) hedgeOut(m *routeMsg) {
+	m.sent = time.Now()
+	m.hedge.ids = append(m.hedge.ids, m.id)
+}
+
+// hedgeIn records the latency of reply.
+func (r *Router) hedgeIn(out *routeMsg) {
+	out.hedge.hs.observe(time.Since(out.sent))
+}
+
+func (r *Router) cancelHedge(hc *hedgeCall) {
+	if hc.tid != InvalidTrackID {
+		r.tt.Del(hc.tid)
+		hc.tid = InvalidTrackID
+	}
+
+	for _, id := range hc.ids {
+		if out, exist := r.calls[id]; exist && out.(*routeMsg).hedge == hc {
+			delete(r.calls, id)
+			r.tt.Del(out.GetTrackID())
+			r.breakerCancel(out.(*routeMsg))
+			r.limitRelease(out.(*routeMsg))
+			out.(*routeMsg).Recycle()
+		}
+	}
+	hc.ids = nil
+}
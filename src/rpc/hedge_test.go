@@ -0,0 +1,100 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRouterHedge(t *testing.T) {
+	pf := NewJSONFactory()
+	for _, rpc := range []string{"hedged", "capped"} {
+		pf.Register(rpc, func() Payload { return new(jsonReq) }, func() Payload { return new(jsonResp) })
+	}
+	hf := NewRPCHeaderFactory(pf)
+
+	network := "tcp"
+	addresses := []string{"localhost:10014", "localhost:10015"}
+
+	echo := func(ctx *RPCContext, p Payload) (Payload, error) {
+		return &jsonResp{Id: p.(*jsonReq).Id}, nil
+	}
+	slow := func(ctx *RPCContext, p Payload) (Payload, error) {
+		time.Sleep(time.Second)
+		return echo(ctx, p)
+	}
+
+	for i, address := range addresses {
+		server, err := NewRouter(nil, JSONProcessPayload)
+		if err != nil {
+			t.FailNow()
+		}
+		h := echo
+		if i == 0 {
+			h = slow
+		}
+		server.HandleRPC("hedged", h)
+		server.HandleRPC("capped", h)
+		server.Run()
+		defer server.Stop()
+
+		if err := server.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+
+	client, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	client.SetGroup("group", []string{"slow", "fast"})
+	client.SetHedgePolicy("hedged", &HedgePolicy{Delay: 200 * time.Millisecond})
+	client.SetHedgePolicy("capped", &HedgePolicy{Delay: 200 * time.Millisecond, MaxRatio: 0.1})
+	client.Run()
+	defer client.Stop()
+
+	for i, name := range []string{"slow", "fast"} {
+		if err := client.Dial(name, network, addresses[i], hf); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+
+	// round robin: fast, slow(hedged to fast)
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		if p, err := client.CallWait("group", "hedged", &jsonReq{Id: uint64(i)}, 5); err != nil {
+			t.Log(err)
+			t.FailNow()
+		} else if p.(*jsonResp).Id != uint64(i) {
+			t.Log(p)
+			t.FailNow()
+		} else if d := time.Since(start); d > 700*time.Millisecond {
+			t.Log(i, d)
+			t.FailNow()
+		}
+	}
+
+	// the budget does not allow hedging: fast, slow
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		if _, err := client.CallWait("group", "capped", &jsonReq{Id: uint64(i)}, 5); err != nil {
+			t.Log(err)
+			t.FailNow()
+		} else if d := time.Since(start); i == 1 && d < time.Second {
+			t.Log(i, d)
+			t.FailNow()
+		}
+	}
+
+	if client.stats.rpcHedge != 1 {
+		t.Log(&client.stats)
+		t.FailNow()
+	}
+
+	// wait the canceled request
+	time.Sleep(time.Second)
+}
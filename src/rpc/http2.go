@@ -0,0 +1,595 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"golang.org/x/net/http2"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultH2MaxBodySize is the default max request body of HTTP/2 listeners,
+// the same as the default max receive message size of gRPC.
+const defaultH2MaxBodySize = 4 * 1024 * 1024
+
+// h2Wire is the encoding of rpc on HTTP/2.
+type h2Wire struct {
+	contentType    string
+	trailerStatus  string
+	trailerMessage string
+
+	// the header of call timeout
+	timeout      string
+	timeoutValue func(time.Duration) string
+	parseTimeout func(string) (time.Duration, bool)
+
+	// the message is length-prefixed
+	prefixed bool
+	// the header prefix of metadata, "" means the headers which are not
+	// reserved.
+	mdPrefix string
+	// the header prefix which is not metadata
+	reserved string
+}
+
+var (
+	lrpcWire = &h2Wire{contentType: "application/lrpc", trailerStatus: "Rpc-Status", trailerMessage: "Rpc-Message",
+		timeout: "Rpc-Timeout-Ms", timeoutValue: lrpcTimeoutValue, parseTimeout: lrpcTimeout, mdPrefix: "Rpc-Metadata-"}
+	// https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md
+	grpcWire = &h2Wire{contentType: "application/grpc", trailerStatus: "Grpc-Status", trailerMessage: "Grpc-Message",
+		timeout: "Grpc-Timeout", timeoutValue: grpcTimeoutValue, parseTimeout: grpcTimeout, prefixed: true, reserved: "Grpc-"}
+)
+
+// The headers which are not metadata.
+var h2Headers = map[string]bool{
+	"Content-Type":    true,
+	"Content-Length":  true,
+	"User-Agent":      true,
+	"Accept-Encoding": true,
+	"Te":              true,
+	"Trailer":         true,
+}
+
+// encode returns the body of message b.
+func (wire *h2Wire) encode(b []byte) []byte {
+	if !wire.prefixed {
+		return b
+	}
+
+	// uncompressed
+	nb := make([]byte, 5, 5+len(b))
+	binary.BigEndian.PutUint32(nb[1:], uint32(len(b)))
+	return append(nb, b...)
+}
+
+// decode returns the message of body b.
+func (wire *h2Wire) decode(b []byte) ([]byte, error) {
+	if !wire.prefixed {
+		return b, nil
+	}
+
+	if len(b) < 5 || binary.BigEndian.Uint32(b[1:]) != uint32(len(b)-5) {
+		return nil, NewStatusError(STATUS_INTERNAL, "malformed message")
+	} else if b[0] != 0 {
+		return nil, NewStatusError(STATUS_UNIMPLEMENTED, "compressed message")
+	}
+	return b[5:], nil
+}
+
+// metadata returns the metadata key of header k, false if it is not metadata.
+func (wire *h2Wire) metadata(k string) (string, bool) {
+	if wire.mdPrefix != "" {
+		if !strings.HasPrefix(k, wire.mdPrefix) || len(k) == len(wire.mdPrefix) {
+			return "", false
+		}
+		return strings.ToLower(k[len(wire.mdPrefix):]), true
+	}
+
+	if h2Headers[k] || (wire.reserved != "" && strings.HasPrefix(k, wire.reserved)) {
+		return "", false
+	}
+	return strings.ToLower(k), true
+}
+
+// h2Header returns the value of trailer k, the trailers-only response has it
+// in header.
+func h2Header(resp *http.Response, k string) string {
+	if v := resp.Trailer.Get(k); v != "" {
+		return v
+	}
+	return resp.Header.Get(k)
+}
+
+// percentEncode encodes the status message as header value.
+func percentEncode(s string) string {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c > 0x7E || c == '%' {
+			if b == nil {
+				b = append(b, s[:i]...)
+			}
+			b = append(b, '%', "0123456789ABCDEF"[c>>4], "0123456789ABCDEF"[c&0xF])
+		} else if b != nil {
+			b = append(b, c)
+		}
+	}
+	if b == nil {
+		return s
+	}
+	return string(b)
+}
+
+func percentDecode(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	if v, err := url.PathUnescape(s); err == nil {
+		return v
+	}
+	return s
+}
+
+// lrpcTimeoutValue returns the timeout header of d in milliseconds.
+func lrpcTimeoutValue(d time.Duration) string {
+	ms := int64(d / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+// lrpcTimeout returns the timeout of header v in milliseconds.
+func lrpcTimeout(v string) (time.Duration, bool) {
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ms < 0 || ms > int64(math.MaxInt64/time.Millisecond) {
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// grpcTimeoutValue returns the grpc-timeout header of d.
+func grpcTimeoutValue(d time.Duration) string {
+	if d < time.Millisecond {
+		d = time.Millisecond
+	}
+	if ms := int64(d / time.Millisecond); ms < 100000000 {
+		return strconv.FormatInt(ms, 10) + "m"
+	}
+	return strconv.FormatInt(int64(d/time.Second), 10) + "S"
+}
+
+// grpcTimeout returns the timeout of grpc-timeout header v.
+func grpcTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	if u, ok := units[v[len(v)-1]]; ok {
+		return time.Duration(n) * u, true
+	}
+	return 0, false
+}
+
+// h2Conn is the frameConn of HTTP/2(h2c) connection, each rpc call is a
+// HTTP/2 stream: POST /<rpc name>, the metadata is headers, the status is
+// trailers. The payload is encoded by pf. Streams and topics are not
+// supported, NewStream and SubscribeEndPoint of it fail with
+// ErrFrameUnsupported.
+type h2Conn struct {
+	c    net.Conn
+	pf   RPCPayloadFactory
+	wire *h2Wire
+
+	in chan *connFrame
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+
+	// client
+	cc        *http2.ClientConn
+	authority string
+
+	// server, the pending requests
+	maxbody int64
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64]chan *connFrame
+}
+
+// h2NetConn closes h2Conn if the underlying connection is broken.
+type h2NetConn struct {
+	net.Conn
+	h *h2Conn
+}
+
+func (c *h2NetConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		c.h.Close()
+	}
+	return n, err
+}
+
+func newH2Conn(c net.Conn, pf RPCPayloadFactory, wire *h2Wire) *h2Conn {
+	h := new(h2Conn)
+	h.c = c
+	h.pf = pf
+	h.wire = wire
+	h.in = make(chan *connFrame, 16*128)
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	h.pending = make(map[uint64]chan *connFrame)
+	return h
+}
+
+// Read blocks until the connection is closed.
+func (h *h2Conn) Read(b []byte) (int, error) {
+	<-h.ctx.Done()
+	return 0, io.EOF
+}
+
+func (h *h2Conn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (h *h2Conn) Close() error {
+	h.once.Do(func() {
+		h.cancel()
+		if h.cc != nil {
+			h.cc.Close()
+		}
+		h.c.Close()
+	})
+	return nil
+}
+
+func (h *h2Conn) LocalAddr() net.Addr {
+	return h.c.LocalAddr()
+}
+
+func (h *h2Conn) RemoteAddr() net.Addr {
+	return h.c.RemoteAddr()
+}
+
+func (h *h2Conn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (h *h2Conn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (h *h2Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (h *h2Conn) cloned() bool {
+	return false
+}
+
+func (h *h2Conn) recv() (*connFrame, error) {
+	select {
+	case f := <-h.in:
+		return f, nil
+	case <-h.ctx.Done():
+		return nil, io.EOF
+	}
+}
+
+// supports returns false for stream and topic frames.
+func (h *h2Conn) supports(flags uint16) bool {
+	return flags&(FRAME_STREAM_DATA|frame_topic_mask) == 0
+}
+
+func (h *h2Conn) send(f *connFrame, q chan struct{}) error {
+	if !f.rpc {
+		return nil
+	} else if !h.supports(f.flags) {
+		// NewStream and SubscribeEndPoint fail with ErrFrameUnsupported,
+		// the stream which is opened anyway is failed locally.
+		if f.flags&FRAME_STREAM_DATA != 0 && f.request {
+			go h.deliver(&connFrame{rpc: true, id: f.id, name: f.name, flags: FRAME_STREAM_DATA | FRAME_STREAM_END, status: STATUS_UNIMPLEMENTED, status_msg: ErrFrameUnsupported.(*StatusError).msg})
+		}
+		return nil
+	}
+
+	if h.cc != nil && f.request {
+		go h.call(f)
+	} else if h.cc == nil && !f.request {
+		h.mu.Lock()
+		ch := h.pending[f.id]
+		delete(h.pending, f.id)
+		h.mu.Unlock()
+
+		if ch != nil {
+			ch <- f
+		}
+	}
+	return nil
+}
+
+func (h *h2Conn) deliver(f *connFrame) {
+	select {
+	case h.in <- f:
+	case <-h.ctx.Done():
+	}
+}
+
+// call sends request f as HTTP/2 stream, the reply is delivered to Reader.
+func (h *h2Conn) call(f *connFrame) {
+	reply := &connFrame{rpc: true, id: f.id, name: f.name}
+	defer h.deliver(reply)
+
+	body, err := h.pf.NewBuffer().Marshal(f.p, nil)
+	if err != nil {
+		reply.status, reply.status_msg = STATUS_INTERNAL, err.Error()
+		return
+	}
+
+	req, err := http.NewRequest("POST", "http://"+h.authority+"/"+f.name, bytes.NewReader(h.wire.encode(body)))
+	if err != nil {
+		reply.status, reply.status_msg = STATUS_INTERNAL, err.Error()
+		return
+	}
+	ctx := h.ctx
+	if !f.to.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(h.ctx, f.to)
+		defer cancel()
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", h.wire.contentType)
+	req.Header.Set("Te", "trailers")
+	for k, v := range f.md {
+		if k == MetaTimeout {
+			// sent as the timeout header of wire
+			continue
+		}
+		req.Header.Set(h.wire.mdPrefix+k, v)
+	}
+	if !f.to.IsZero() {
+		req.Header.Set(h.wire.timeout, h.wire.timeoutValue(time.Until(f.to)))
+	}
+
+	resp, err := h.cc.RoundTrip(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			reply.status, reply.status_msg = STATUS_DEADLINE_EXCEEDED, err.Error()
+		} else {
+			reply.status, reply.status_msg = STATUS_UNAVAILABLE, err.Error()
+		}
+		return
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		reply.status, reply.status_msg = STATUS_UNAVAILABLE, err.Error()
+		return
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		reply.status, reply.status_msg = STATUS_INTERNAL, resp.Status
+		return
+	}
+	if n, err := strconv.Atoi(h2Header(resp, h.wire.trailerStatus)); err != nil {
+		reply.status, reply.status_msg = STATUS_INTERNAL, "no status"
+		return
+	} else if reply.status = Status(n); reply.status != STATUS_OK {
+		reply.status_msg = percentDecode(h2Header(resp, h.wire.trailerMessage))
+		return
+	}
+
+	if b, err = h.wire.decode(b); err != nil {
+		reply.status, reply.status_msg = StatusOf(err), err.(*StatusError).Message()
+	} else if reply.p, err = h.pf.NewBuffer().Unmarshal(f.name, false, b); err != nil {
+		reply.status, reply.status_msg = STATUS_INTERNAL, err.Error()
+	}
+}
+
+// status writes the status trailers.
+func (h *h2Conn) status(w http.ResponseWriter, s Status, msg string) {
+	w.Header().Set(h.wire.trailerStatus, strconv.Itoa(int(s)))
+	w.Header().Set(h.wire.trailerMessage, percentEncode(msg))
+}
+
+// ServeHTTP passes the request to Reader and waits for the reply.
+func (h *h2Conn) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), h.wire.contentType) {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	name := strings.TrimPrefix(req.URL.Path, "/")
+	f := &connFrame{rpc: true, request: true, name: name}
+
+	w.Header().Set("Content-Type", h.wire.contentType)
+	w.Header().Set("Trailer", h.wire.trailerStatus+", "+h.wire.trailerMessage)
+
+	if b, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, h.maxbody)); err != nil {
+		if _, ok := err.(*http.MaxBytesError); ok {
+			w.WriteHeader(http.StatusOK)
+			h.status(w, STATUS_RESOURCE_EXHAUSTED, err.Error())
+		}
+		return
+	} else if b, err = h.wire.decode(b); err != nil {
+		w.WriteHeader(http.StatusOK)
+		h.status(w, StatusOf(err), err.(*StatusError).Message())
+		return
+	} else if f.p, err = h.pf.NewBuffer().Unmarshal(name, true, b); err != nil {
+		w.WriteHeader(http.StatusOK)
+		h.status(w, STATUS_INVALID_ARGUMENT, err.Error())
+		return
+	}
+
+	for k, vs := range req.Header {
+		key, ok := h.wire.metadata(k)
+		if !ok || len(vs) == 0 {
+			continue
+		}
+		if f.md == nil {
+			f.md = make(Metadata)
+		}
+		f.md[key] = vs[0]
+	}
+
+	var timeout <-chan time.Time
+	if d, ok := h.wire.parseTimeout(req.Header.Get(h.wire.timeout)); ok {
+		f.md = f.md.withTimeout(time.Now().Add(d))
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	ch := make(chan *connFrame, 1)
+	h.mu.Lock()
+	h.next++
+	f.id = h.next
+	h.pending[f.id] = ch
+	h.mu.Unlock()
+
+	h.deliver(f)
+
+	var reply *connFrame
+	select {
+	case reply = <-ch:
+	case <-timeout:
+		reply = &connFrame{status: STATUS_DEADLINE_EXCEEDED, status_msg: "deadline exceeded"}
+	case <-req.Context().Done():
+	case <-h.ctx.Done():
+	}
+	if reply == nil || reply.status == STATUS_DEADLINE_EXCEEDED {
+		h.mu.Lock()
+		delete(h.pending, f.id)
+		h.mu.Unlock()
+	}
+	if reply == nil {
+		return
+	}
+
+	var body []byte
+	if reply.status == STATUS_OK {
+		if b, err := h.pf.NewBuffer().Marshal(reply.p, nil); err != nil {
+			reply.status, reply.status_msg = STATUS_INTERNAL, err.Error()
+		} else {
+			body = h.wire.encode(b)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+	h.status(w, reply.status, reply.status_msg)
+}
+
+// h2Listener accepts HTTP/2 connections.
+type h2Listener struct {
+	net.Listener
+	pf      RPCPayloadFactory
+	wire    *h2Wire
+	s       *http2.Server
+	maxbody int
+}
+
+func (l *h2Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	h := newH2Conn(c, l.pf, l.wire)
+	h.maxbody = int64(l.maxbody)
+	go func() {
+		l.s.ServeConn(&h2NetConn{Conn: c, h: h}, &http2.ServeConnOpts{Context: h.ctx, Handler: h})
+		h.Close()
+	}()
+	return h, nil
+}
+
+func (r *Router) dialH2(name string, network string, address string, pf RPCPayloadFactory, wire *h2Wire) error {
+	c, err := net.Dial(network, address)
+	if err != nil {
+		return err
+	}
+
+	h := newH2Conn(c, pf, wire)
+	h.authority = address
+	t := &http2.Transport{AllowHTTP: true}
+	if h.cc, err = t.NewClientConn(&h2NetConn{Conn: c, h: h}); err != nil {
+		c.Close()
+		return err
+	}
+
+	return r.addConn(name, h, frameFactory{})
+}
+
+func (r *Router) listenH2(name string, network string, address string, pf RPCPayloadFactory, wire *h2Wire) error {
+	if l, err := net.Listen(network, address); err != nil {
+		return err
+	} else if l, err := NewListener(name, &h2Listener{Listener: l, pf: pf, wire: wire, s: new(http2.Server), maxbody: r.h2maxbody}, frameFactory{}, r, nil); err != nil {
+		return err
+	} else if err := r.AddListener(l); err != nil {
+		l.Stop()
+		return err
+	}
+
+	return nil
+}
+
+// SetMaxHTTP2BodySize sets the max request body of new HTTP/2 listeners, the
+// larger request fails with RESOURCE_EXHAUSTED. 0 means the default(4MB). It
+// should be called before ListenAndServeHTTP2()/ListenAndServeGRPC().
+func (r *Router) SetMaxHTTP2BodySize(n int) {
+	if n <= 0 {
+		n = defaultH2MaxBodySize
+	}
+	r.h2maxbody = n
+}
+
+// DialHTTP2 is Dial which sends the rpc calls as HTTP/2 streams without
+// TLS(h2c), pf encodes the payloads. The metadata is sent as Rpc-Metadata-*
+// headers, the timeout as Rpc-Timeout-Ms. Streams and topics are not
+// supported(ErrFrameUnsupported).
+func (r *Router) DialHTTP2(name string, network string, address string, pf RPCPayloadFactory) error {
+	return r.dialH2(name, network, address, pf, lrpcWire)
+}
+
+// ListenAndServeHTTP2 is ListenAndServe of HTTP/2 without TLS(h2c), pf
+// encodes the payloads. Only the Rpc-Metadata-* headers of requests are
+// metadata, see DialHTTP2.
+func (r *Router) ListenAndServeHTTP2(name string, network string, address string, pf RPCPayloadFactory) error {
+	return r.listenH2(name, network, address, pf, lrpcWire)
+}
+
+// DialGRPC is DialHTTP2 to the gRPC server(h2c), the rpc name is the full
+// method name without the leading "/"("package.Service/Method"). pf is
+// usually NewProtobufFactory. Only unary calls are supported.
+func (r *Router) DialGRPC(name string, network string, address string, pf RPCPayloadFactory) error {
+	return r.dialH2(name, network, address, pf, grpcWire)
+}
+
+// ListenAndServeGRPC is ListenAndServeHTTP2 for the gRPC clients(h2c), see
+// DialGRPC. The grpc-timeout of request is honored.
+func (r *Router) ListenAndServeGRPC(name string, network string, address string, pf RPCPayloadFactory) error {
+	return r.listenH2(name, network, address, pf, grpcWire)
+}
@@ -0,0 +1,183 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"golang.org/x/net/http2"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouterHTTP2(t *testing.T) {
+	pf := NewJSONFactory()
+	pf.Register("echo", func() Payload { return new(jsonReq) }, func() Payload { return new(jsonReq) })
+	pf.Register("slow", func() Payload { return new(jsonReq) }, func() Payload { return new(jsonReq) })
+
+	network := "tcp"
+	address := "localhost:10024"
+
+	server, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	server.HandleRPC("echo", func(ctx *RPCContext, p Payload) (Payload, error) {
+		if ctx.Metadata["key"] != "value" {
+			return nil, NewStatusError(STATUS_INVALID_ARGUMENT, "metadata")
+		}
+		return p, nil
+	})
+	server.HandleRPC("slow", func(ctx *RPCContext, p Payload) (Payload, error) {
+		time.Sleep(time.Second)
+		return p, nil
+	})
+	server.Run()
+	defer server.Stop()
+
+	client, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	client.Run()
+	defer client.Stop()
+
+	server.SetMaxHTTP2BodySize(256 * 1024)
+	if err := server.ListenAndServeHTTP2("client", network, address, pf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := client.DialHTTP2("server", network, address, pf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	md := Metadata{"key": "value"}
+
+	if p, err := client.CallWait("server", "echo", WithMetadata(md, &jsonReq{Id: 1}), 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if resp, ok := p.(*jsonReq); !ok || resp.Id != 1 {
+		t.Log(p)
+		t.FailNow()
+	}
+
+	// status
+	if _, err := client.CallWait("server", "echo", &jsonReq{Id: 2}, 5); StatusOf(err) != STATUS_INVALID_ARGUMENT {
+		t.Log(err)
+		t.FailNow()
+	} else if se, ok := err.(*StatusError); !ok || se.Message() != "metadata" {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// concurrent streams
+	n := 64
+	done := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			p, err := client.CallWait("server", "echo", WithMetadata(md, &jsonReq{Id: uint64(i)}), 5)
+			if err == nil && p.(*jsonReq).Id != uint64(i) {
+				err = fmt.Errorf("reply %v of request %v", p.(*jsonReq).Id, i)
+			}
+			done <- err
+		}(i)
+	}
+	for i := 0; i < n; i++ {
+		if err := <-done; err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+
+	// the request body is limited
+	if p, err := client.CallWait("server", "echo", WithMetadata(md, &jsonReq{Id: 4, Name: strings.Repeat("x", 192*1024)}), 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if resp, ok := p.(*jsonReq); !ok || len(resp.Name) != 192*1024 {
+		t.FailNow()
+	}
+	if _, err := client.CallWait("server", "echo", WithMetadata(md, &jsonReq{Id: 5, Name: strings.Repeat("x", 512*1024)}), 5); StatusOf(err) != STATUS_RESOURCE_EXHAUSTED {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// streams and topics are not supported
+	if _, err := client.NewStream("server", "echo", 5); err != ErrFrameUnsupported {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := client.SubscribeEndPoint("server", "topic"); err != ErrFrameUnsupported {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// the deadline is sent as Rpc-Timeout-Ms(lrpc) or grpc-timeout(grpc)
+	for _, d := range []time.Duration{time.Millisecond, 20 * time.Millisecond, 5 * time.Second, 48 * time.Hour} {
+		if v, ok := lrpcTimeout(lrpcTimeoutValue(d)); !ok || v != d {
+			t.Log(d, v)
+			t.FailNow()
+		}
+	}
+	for _, d := range []time.Duration{time.Millisecond, 20 * time.Millisecond, 5 * time.Second, 48 * time.Hour} {
+		if v, ok := grpcTimeout(grpcTimeoutValue(d)); !ok || v != d {
+			t.Log(d, v)
+			t.FailNow()
+		}
+	}
+
+	// plain HTTP/2 client
+	c := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	post := func(rpc string, header map[string]string) (string, []byte) {
+		req, _ := http.NewRequest("POST", "http://"+address+"/"+rpc, bytes.NewReader([]byte(`{"id": 3}`)))
+		req.Header.Set("Content-Type", "application/lrpc")
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.Trailer.Get("Rpc-Status"), b
+	}
+	if status, b := post("echo", map[string]string{"Rpc-Metadata-Key": "value"}); status != "0" || !bytes.Contains(b, []byte(`"id":3`)) {
+		t.Log(status, string(b))
+		t.FailNow()
+	}
+	// only the prefixed headers are metadata
+	if status, b := post("echo", map[string]string{"Key": "value", "User-Agent": "value"}); status != strconv.Itoa(int(STATUS_INVALID_ARGUMENT)) {
+		t.Log(status, string(b))
+		t.FailNow()
+	}
+	start := time.Now()
+	if status, b := post("slow", map[string]string{"Rpc-Timeout-Ms": "100"}); status != strconv.Itoa(int(STATUS_DEADLINE_EXCEEDED)) || time.Since(start) > 900*time.Millisecond {
+		t.Log(status, string(b), time.Since(start))
+		t.FailNow()
+	}
+
+	// the connection is closed with the EndPoint
+	if err := client.DelEndPoint("server"); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := client.CallWait("server", "echo", WithMetadata(md, &jsonReq{Id: 1}), 1); err == nil {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInProcessClosed  error = &Error{err: "in-process connection closed"}
+	ErrFrameUnsupported error = &StatusError{code: STATUS_UNIMPLEMENTED, msg: "frame is not supported by EndPoint"}
+)
+
+// frameConn is the connection which passes connFrame instead of bytes, the
+// Reader and Writer of EndPoint use recv/send.
+type frameConn interface {
+	recv() (*connFrame, error)
+	send(f *connFrame, q chan struct{}) error
+	// the message is marshaled by MsgBuffer of EndPoint
+	cloned() bool
+}
+
+// frameFilter is implemented by the connection which can not pass all kinds
+// of frames.
+type frameFilter interface {
+	supports(flags uint16) bool
+}
+
+// supports returns false if the connection of ep can not pass the frames of
+// flags.
+func (ep *EndPoint) supports(flags uint16) bool {
+	if ff, ok := ep.conn.(frameFilter); ok {
+		return ff.supports(flags)
+	}
+	return true
+}
+
+// connFrame is the message passed by frameConn.
+type connFrame struct {
+	// marshaled frame(header and payload) if the messages are cloned,
+	// otherwise p and the rpc info.
+	b []byte
+
+	p          Payload
+	rpc        bool
+	request    bool
+	id         uint64
+	name       string
+	flags      uint16
+	status     Status
+	status_msg string
+	md         Metadata
+	to         time.Time // the deadline of request
+}
+
+// setInfo records the rpc info of p, it is SetPayloadInfo of MsgBuffer.
+func (f *connFrame) setInfo(p Payload) {
+	if rp, ok := p.(RoutePayload); !ok || !rp.IsRPC() {
+		return
+	}
+
+	i := p.(RPCInfo)
+	f.rpc = true
+	f.id = i.GetRPCID()
+	f.name = i.GetRPCName()
+	f.request = i.IsRequest()
+	f.flags = marshalFrameFlags(p)
+	if m, ok := p.(*routeMsg); ok && f.request {
+		f.to = m.to
+	}
+
+	if mi, ok := p.(MetaInfo); ok {
+		f.status, f.status_msg = mi.GetStatus()
+		f.md = mi.GetMetadata()
+	}
+}
+
+// getInfo sets the rpc info to p, it is GetPayloadInfo of MsgBuffer.
+func (f *connFrame) getInfo(p Payload) {
+	rp, ok := p.(RoutePayload)
+	if !ok || !f.rpc {
+		return
+	}
+
+	rp.SetIsRPC()
+	i := p.(RPCInfo)
+	i.SetRPCID(f.id)
+	i.SetRPCName(f.name)
+	if f.request {
+		i.SetIsRequest()
+	}
+	unmarshalFrameFlags(f.flags, p)
+
+	if mi, ok := p.(MetaInfo); ok {
+		mi.SetStatus(f.status, f.status_msg)
+		mi.SetMetadata(f.md)
+	}
+}
+
+type inprocAddr string
+
+func (a inprocAddr) Network() string {
+	return "inproc"
+}
+
+func (a inprocAddr) String() string {
+	return string(a)
+}
+
+// inprocConn is one side of in-process connection, Reader and Writer pass
+// connFrame by channels instead of Read/Write.
+type inprocConn struct {
+	in  chan *connFrame
+	out chan *connFrame
+
+	// marshal the messages
+	clone bool
+
+	closed chan struct{}
+	once   *sync.Once
+
+	local  inprocAddr
+	remote inprocAddr
+}
+
+func newInprocConnPair(a string, b string, clone bool) (*inprocConn, *inprocConn) {
+	ab := make(chan *connFrame, 16*128)
+	ba := make(chan *connFrame, 16*128)
+	closed := make(chan struct{})
+	once := new(sync.Once)
+
+	ca := &inprocConn{in: ba, out: ab, clone: clone, closed: closed, once: once, local: inprocAddr(b), remote: inprocAddr(a)}
+	cb := &inprocConn{in: ab, out: ba, clone: clone, closed: closed, once: once, local: inprocAddr(a), remote: inprocAddr(b)}
+	return ca, cb
+}
+
+// Read blocks until the connection is closed.
+func (c *inprocConn) Read(b []byte) (int, error) {
+	<-c.closed
+	return 0, io.EOF
+}
+
+func (c *inprocConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, ErrInProcessClosed
+	default:
+		return len(b), nil
+	}
+}
+
+// Close closes both sides.
+func (c *inprocConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *inprocConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *inprocConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *inprocConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *inprocConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *inprocConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *inprocConn) recv() (*connFrame, error) {
+	select {
+	case f := <-c.in:
+		return f, nil
+	case <-c.closed:
+		return nil, io.EOF
+	}
+}
+
+func (c *inprocConn) send(f *connFrame, q chan struct{}) error {
+	select {
+	case <-q:
+		return errQuit
+	case <-c.closed:
+		return ErrInProcessClosed
+	case c.out <- f:
+		return nil
+	}
+}
+
+func (c *inprocConn) cloned() bool {
+	return c.clone
+}
+
+// receive returns the next message of frameConn.
+func (r *Reader) receive() (Payload, error) {
+	f, err := r.local.recv()
+	if err != nil {
+		return nil, err
+	}
+
+	if f.b != nil {
+		return r.unmarshalFragments(f.b)
+	}
+	p := r.io.Wrap(f.p)
+	f.getInfo(p)
+	return p, nil
+}
+
+// forward passes the next message to frameConn.
+func (w *Writer) forward(q chan struct{}) error {
+	p, ok := w.sched.next(&w.outs)
+	if !ok {
+		select {
+		case <-q:
+			return errQuit
+		case p = <-w.outs[PRIORITY_HIGHEST]:
+		case p = <-w.outs[PRIORITY_HIGHER]:
+		case p = <-w.outs[PRIORITY_HIGH]:
+		case p = <-w.outs[PRIORITY_NORMAL]:
+		}
+	}
+	if p == nil {
+		return errQuit
+	}
+
+	f := new(connFrame)
+	if w.local.cloned() {
+		if err := w.Marshal(p); err != nil {
+			return err
+		}
+		f.b = append([]byte(nil), w.b[w.b_data_offset:w.b_alloc_offset]...)
+		w.b = w.ob
+		w.b_data_offset = 0
+		w.b_alloc_offset = 0
+	} else {
+		f.setInfo(p)
+		_, f.p = unwrapCodec(w.io.Unwrap(p))
+	}
+
+	return w.local.send(f, q)
+}
+
+// frameFactory is the MsgFactory of frameConn EndPoints which pass Payload
+// directly, the MsgBuffer is not used.
+type frameFactory struct{}
+
+func (f frameFactory) NewBuffer() MsgBuffer {
+	return nil
+}
+
+// DialInProcess connects r and peer in process without syscalls, name is the
+// EndPoint of peer on r, peerName is the EndPoint of r on peer(r itself is
+// allowed). If mf is nil, the Payload and Metadata objects are passed to peer
+// directly(shared); otherwise they are cloned by marshaling with mf.
+func (r *Router) DialInProcess(name string, peer *Router, peerName string, mf MsgFactory) error {
+	a, b := newInprocConnPair(name, peerName, mf != nil)
+	if mf == nil {
+		mf = frameFactory{}
+	}
+
+	if err := r.addConn(name, a, mf); err != nil {
+		b.Close()
+		return err
+	}
+	// r's EndPoint is closed with b if it fails.
+	return peer.addConn(peerName, b, mf)
+}
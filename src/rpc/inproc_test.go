@@ -0,0 +1,116 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRouterInProcess(t *testing.T) {
+	pf := NewJSONFactory()
+	pf.Register("echo", func() Payload { return new(jsonReq) }, func() Payload { return new(jsonReq) })
+	hf := NewRPCHeaderFactory(pf)
+
+	server, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	server.HandleRPC("echo", func(ctx *RPCContext, p Payload) (Payload, error) {
+		if ctx.Metadata["key"] != "value" {
+			return nil, NewStatusError(STATUS_INVALID_ARGUMENT, "metadata")
+		}
+		return p, nil
+	})
+	server.Run()
+	defer server.Stop()
+
+	client, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	client.Run()
+	defer client.Stop()
+
+	if err := client.DialInProcess("server", server, "client", nil); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := client.DialInProcess("cloned", server, "cloned", hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := server.DialInProcess("self", server, "self.peer", nil); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	md := Metadata{"key": "value"}
+
+	// the payload is passed directly
+	req := &jsonReq{Id: 1}
+	if p, err := client.CallWait("server", "echo", WithMetadata(md, req), 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if p != req {
+		t.Log(p)
+		t.FailNow()
+	}
+
+	// the payload is cloned
+	if p, err := client.CallWait("cloned", "echo", WithMetadata(md, req), 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if resp, ok := p.(*jsonReq); !ok || resp == req || resp.Id != 1 {
+		t.Log(p)
+		t.FailNow()
+	}
+
+	// status
+	if _, err := client.CallWait("server", "echo", req, 5); StatusOf(err) != STATUS_INVALID_ARGUMENT {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if p, err := server.CallWait("self", "echo", WithMetadata(md, req), 5); err != nil || p != req {
+		t.Log(p, err)
+		t.FailNow()
+	}
+
+	// both sides are closed
+	if err := client.DelEndPoint("server"); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := server.DelEndPoint("client"); err != ErrOPEndPointNotExist {
+		t.Log(err)
+		t.FailNow()
+	}
+}
+
+func BenchmarkInProcessSeperateRouter(b *testing.B) {
+	server_r, err := NewRouter(nil, ServiceProcessPayload)
+	if err != nil {
+		b.FailNow()
+	}
+	client_r, err := NewRouter(nil, ServiceProcessPayload)
+	if err != nil {
+		b.FailNow()
+	}
+
+	server_r.Run()
+	client_r.Run()
+	<-time.Tick(1 * time.Millisecond)
+
+	name := "scheduler"
+	n := ConcurrentNum
+	m := GoRoutineRequests
+	for i := 0; i < n; i++ {
+		client_r.DialInProcess(name+string(rune(i)), server_r, "client"+string(rune(i)), nil)
+	}
+
+	<-time.Tick(1 * time.Millisecond)
+	testSeperateRouter(b, server_r, client_r, n, m)
+}
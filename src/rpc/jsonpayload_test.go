@@ -0,0 +1,120 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"io"
+	mi "rpc/msg_interface"
+	"testing"
+	"time"
+)
+
+const (
+	_ = iota
+	jsonReqID
+	jsonRespID
+)
+
+type jsonReq struct {
+	Id   uint64 `json:"id"`
+	Name string `json:"name"`
+}
+
+func (p *jsonReq) GetMsgPayloadID() uint16 {
+	return jsonReqID
+}
+
+type jsonResp struct {
+	Id uint64 `json:"id"`
+}
+
+func (p *jsonResp) GetMsgPayloadID() uint16 {
+	return jsonRespID
+}
+
+func JSONProcessPayload(r *Router, name string, p Payload) Payload {
+	if req, ok := p.(*jsonReq); ok {
+		return &jsonResp{Id: req.Id}
+	} else if m, ok := p.(map[string]interface{}); ok {
+		// unregistered rpc
+		return m
+	} else {
+		panic("JSONProcessPayload receieve wrong info")
+	}
+}
+
+func TestRouterJSON(t *testing.T) {
+	r, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+
+	pf := NewJSONFactory()
+	pf.Register("rpc", func() Payload { return new(jsonReq) }, func() Payload { return new(jsonResp) })
+	hf := NewRPCHeaderFactory(pf)
+
+	name := "scheduler"
+	network := "tcp"
+	address := "localhost:10003"
+
+	r.Run()
+	defer r.Stop()
+
+	if err := r.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := r.Dial(name, network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if p, err := r.CallWait(name, "rpc", &jsonReq{Id: 1, Name: "<json>"}, 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if resp, ok := p.(*jsonResp); !ok || resp.Id != 1 {
+		t.Log(p)
+		t.FailNow()
+	}
+
+	if p, err := r.CallWait(name, "echo", map[string]interface{}{"id": 2}, 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if m, ok := p.(map[string]interface{}); !ok || m["id"].(float64) != 2 {
+		t.Log(p)
+		t.FailNow()
+	}
+}
+
+func TestMsgJSON(t *testing.T) {
+	pr, pw := io.Pipe()
+	ch := make(chanPayload, 128)
+
+	pf := NewMsgJSONFactory()
+	pf.Register(jsonReqID, func() mi.MsgPayload { return new(jsonReq) })
+	pf.Register(jsonRespID, func() mi.MsgPayload { return new(jsonResp) })
+	hf := NewMsgHeaderFactory(pf)
+
+	w := NewWriter(pw, ch, hf.NewBuffer(), nil)
+	r := NewReader(pr, ch, hf.NewBuffer(), nil)
+
+	w.Run()
+	r.Run()
+
+	w.Write(&jsonReq{Id: 10000, Name: "json"})
+
+	select {
+	case p := <-ch:
+		if req, ok := p.(*jsonReq); !ok || req.Id != 10000 || req.Name != "json" {
+			t.Log(p)
+			t.Fail()
+		}
+	case <-time.After(1 * time.Second):
+		t.Log("timeout")
+		t.FailNow()
+	}
+
+	r.Stop()
+	w.Stop()
+}
@@ -0,0 +1,248 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"time"
+)
+
+var (
+	ErrLimitExceeded error = &Error{err: "Call limit exceeded"}
+)
+
+// LimitPolicy limits the in-flight calls and the request rate(token bucket).
+// The call over limit waits in a queue of Queue calls, or it is rejected with
+// ErrLimitExceeded.
+type LimitPolicy struct {
+	// 0 means unlimited.
+	MaxInFlight int
+	// Requests per second, 0 means unlimited.
+	Rate float64
+	// Bucket size, 1 if it is 0.
+	Burst int
+	// 0 rejects the call immediately.
+	Queue int
+}
+
+// limiter is accessed inside router goroutine.
+type limiter struct {
+	lp *LimitPolicy
+
+	inflight int
+	tokens   float64
+	last     time.Time
+
+	queue []*routeMsg
+}
+
+func newLimiter(lp *LimitPolicy) *limiter {
+	l := new(limiter)
+	l.lp = lp
+	l.tokens = float64(l.burst())
+	l.last = time.Now()
+	return l
+}
+
+func (l *limiter) burst() int {
+	if l.lp.Burst <= 0 {
+		return 1
+	}
+	return l.lp.Burst
+}
+
+func (l *limiter) ok(now time.Time) bool {
+	if l.lp.Rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * l.lp.Rate
+		if max := float64(l.burst()); l.tokens > max {
+			l.tokens = max
+		}
+		l.last = now
+	}
+
+	return (l.lp.MaxInFlight <= 0 || l.inflight < l.lp.MaxInFlight) &&
+		(l.lp.Rate <= 0 || l.tokens >= 1)
+}
+
+func (l *limiter) take() {
+	l.inflight++
+	if l.lp.Rate > 0 {
+		l.tokens--
+	}
+}
+
+// SetEndPointLimit limits the calls to EndPoint ep, "" is the default of all
+// EndPoints. nil removes the limit. It should be called before Run().
+func (r *Router) SetEndPointLimit(ep string, lp *LimitPolicy) {
+	if lp == nil {
+		delete(r.epLimitPolicies, ep)
+	} else {
+		r.epLimitPolicies[ep] = lp
+	}
+}
+
+// SetMethodLimit limits the calls of rpc, nil removes the limit. It should be
+// called before Run().
+func (r *Router) SetMethodLimit(rpc string, lp *LimitPolicy) {
+	if lp == nil {
+		delete(r.rpcLimitPolicies, rpc)
+	} else {
+		r.rpcLimitPolicies[rpc] = lp
+	}
+}
+
+func (r *Router) epLimiter(name string) *limiter {
+	if l, exist := r.epLimiters[name]; exist {
+		return l
+	}
+
+	lp, exist := r.epLimitPolicies[name]
+	if !exist {
+		if lp, exist = r.epLimitPolicies[""]; !exist {
+			return nil
+		}
+	}
+
+	l := newLimiter(lp)
+	r.epLimiters[name] = l
+	return l
+}
+
+func (r *Router) rpcLimiter(rpc string) *limiter {
+	if l, exist := r.rpcLimiters[rpc]; exist {
+		return l
+	}
+
+	lp, exist := r.rpcLimitPolicies[rpc]
+	if !exist {
+		return nil
+	}
+
+	l := newLimiter(lp)
+	r.rpcLimiters[rpc] = l
+	return l
+}
+
+// limitTake takes the in-flight slots and tokens of m if both limiters allow.
+func (r *Router) limitTake(m *routeMsg, el *limiter, ml *limiter, now time.Time) bool {
+	if el != nil && !el.ok(now) {
+		return false
+	} else if ml != nil && !ml.ok(now) {
+		return false
+	}
+
+	if el != nil {
+		el.take()
+	}
+	if ml != nil {
+		ml.take()
+	}
+	m.limited = true
+	return true
+}
+
+// limitAcquire returns false if request m is queued or rejected.
+func (r *Router) limitAcquire(m *routeMsg) bool {
+	if len(r.epLimitPolicies) == 0 && len(r.rpcLimitPolicies) == 0 {
+		return true
+	}
+
+	el, ml := r.epLimiter(m.ep_name), r.rpcLimiter(m.rpc)
+	if el == nil && ml == nil {
+		return true
+	}
+
+	// first in first out
+	queued := (el != nil && len(el.queue) > 0) || (ml != nil && len(ml.queue) > 0)
+	now := time.Now()
+	if !queued && r.limitTake(m, el, ml, now) {
+		return true
+	}
+
+	// wait in the queue of blocking limiter
+	l := el
+	if l == nil || (len(l.queue) == 0 && l.ok(now)) {
+		l = ml
+	}
+
+	if len(l.queue) < l.lp.Queue {
+		l.queue = append(l.queue, m)
+		r.stats.limitQueue++
+		return false
+	}
+
+	r.stats.limitReject++
+	if !r.outError(m, ErrLimitExceeded) {
+		m.Recycle()
+	}
+	return false
+}
+
+// limitRelease releases the in-flight slots of request m.
+func (r *Router) limitRelease(m *routeMsg) {
+	if !m.limited {
+		return
+	}
+	m.limited = false
+
+	el, ml := r.epLimiter(m.ep_name), r.rpcLimiter(m.rpc)
+	if el != nil {
+		el.inflight--
+		r.limitDrain(el, time.Now())
+	}
+	if ml != nil {
+		ml.inflight--
+		r.limitDrain(ml, time.Now())
+	}
+}
+
+// limitDrain sends the queued requests of l.
+func (r *Router) limitDrain(l *limiter, now time.Time) {
+	for len(l.queue) > 0 {
+		m := l.queue[0]
+
+		if !now.Before(m.to) {
+			l.queue = l.queue[1:]
+			r.stats.rpcTimeout++
+			if !r.outError(m, ErrCallTimeout) {
+				m.Recycle()
+			}
+			continue
+		}
+
+		if !r.limitTake(m, r.epLimiter(m.ep_name), r.rpcLimiter(m.rpc), now) {
+			break
+		}
+		l.queue = l.queue[1:]
+
+		if r.rpcRequestOut(m) {
+			r.writeOut(m)
+		}
+	}
+}
+
+// limitTick sends the queued requests when tokens are refilled.
+func (r *Router) limitTick(now time.Time) {
+	for _, l := range r.epLimiters {
+		r.limitDrain(l, now)
+	}
+	for _, l := range r.rpcLimiters {
+		r.limitDrain(l, now)
+	}
+}
+
+// limitCancel fails all queued requests.
+func (r *Router) limitCancel(err error) {
+	for _, ls := range []map[string]*limiter{r.epLimiters, r.rpcLimiters} {
+		for _, l := range ls {
+			for _, m := range l.queue {
+				if m.cb != nil {
+					cb, arg := m.cb, m.arg
+					go cb(nil, arg, err)
+				}
+				m.Recycle()
+			}
+			l.queue = nil
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRouterLimit(t *testing.T) {
+	pf := NewJSONFactory()
+	hf := NewRPCHeaderFactory(pf)
+
+	network := "tcp"
+	address := "localhost:10017"
+
+	server, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	slow := func(ctx *RPCContext, p Payload) (Payload, error) {
+		time.Sleep(300 * time.Millisecond)
+		return p, nil
+	}
+	server.HandleRPC("queued", slow)
+	server.HandleRPC("rejected", slow)
+	server.HandleRPC("rate", func(ctx *RPCContext, p Payload) (Payload, error) {
+		return p, nil
+	})
+	server.Run()
+	defer server.Stop()
+
+	client, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	client.SetEndPointLimit("server", &LimitPolicy{MaxInFlight: 2, Queue: 16})
+	client.SetMethodLimit("rejected", &LimitPolicy{MaxInFlight: 1})
+	client.SetMethodLimit("rate", &LimitPolicy{Rate: 10, Queue: 16})
+	client.Run()
+	defer client.Stop()
+
+	if err := server.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := client.Dial("server", network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	call := func(rpc string, n int) (time.Duration, []error) {
+		var wg sync.WaitGroup
+		errs := make([]error, n)
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				_, errs[i] = client.CallWait("server", rpc, nil, 5)
+				wg.Done()
+			}(i)
+		}
+		wg.Wait()
+		return time.Since(start), errs
+	}
+
+	// 2 in flight, 2 queued
+	if d, errs := call("queued", 4); d < 600*time.Millisecond {
+		t.Log(d)
+		t.FailNow()
+	} else {
+		for _, err := range errs {
+			if err != nil {
+				t.Log(err)
+				t.FailNow()
+			}
+		}
+	}
+
+	// 1 in flight, 1 rejected
+	_, errs := call("rejected", 2)
+	if !((errs[0] == nil && errs[1] == ErrLimitExceeded) || (errs[1] == nil && errs[0] == ErrLimitExceeded)) {
+		t.Log(errs)
+		t.FailNow()
+	}
+
+	// 10 requests per second, 1 burst
+	if d, errs := call("rate", 5); d < 300*time.Millisecond {
+		t.Log(d)
+		t.FailNow()
+	} else {
+		for _, err := range errs {
+			if err != nil {
+				t.Log(err)
+				t.FailNow()
+			}
+		}
+	}
+}
@@ -5,6 +5,7 @@ package rpc
 
 import (
 	"net"
+	"strconv"
 )
 
 type Listener struct {
@@ -16,6 +17,8 @@ type Listener struct {
 	r     *Router
 	serve ServeConn
 
+	seq uint64 // the accepted connections without remote address
+
 	bg *BackgroudService
 }
 
@@ -65,6 +68,16 @@ func (l *Listener) Cleanup() {
 	// nothing to do
 }
 
+// epName returns the EndPoint name of accepted connection c, the Unix socket
+// peer has no address.
+func (l *Listener) epName(c net.Conn) string {
+	if a := c.RemoteAddr(); a != nil && a.String() != "" && a.String() != "@" {
+		return l.name + a.String()
+	}
+	l.seq++
+	return l.name + "#" + strconv.FormatUint(l.seq, 10)
+}
+
 func (l *Listener) accepter() {
 	for {
 		if c, err := l.l.Accept(); err != nil {
@@ -72,13 +85,22 @@ func (l *Listener) accepter() {
 			break
 		} else if l.serve != nil && l.serve(l.r, c) {
 			// TODO: serve
-		} else if ep := l.r.newRouterEndPoint(l.name+c.RemoteAddr().String(), c, l.mf); ep == nil {
-			c.Close()
-			break
-		} else if err := l.r.AddEndPoint(ep); err != nil {
-			ep.Stop()
 		} else {
-			//l.r.logger.Print(ep)
+			// the handshake may take long, don't block the accepter.
+			go l.accept(l.epName(c), c)
 		}
 	}
 }
+
+// accept sets up the EndPoint of accepted connection c.
+func (l *Listener) accept(name string, c net.Conn) {
+	if ep, err := l.r.newConnEndPoint(name, c, l.mf); err != nil {
+		// TODO: log? handshake failure
+		c.Close()
+	} else if ep == nil {
+		// the Router is stopped
+		c.Close()
+	} else if err := l.r.AddEndPoint(ep); err != nil {
+		ep.Stop()
+	}
+}
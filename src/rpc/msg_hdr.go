@@ -15,7 +15,8 @@ const (
 type msgHeader struct {
 	length         uint32
 	rpcid          uint64
-	version        uint16
+	codec          uint8
+	version        uint8
 	flags          uint16
 	payload_id     uint16
 	payload_offset uint16
@@ -24,12 +25,21 @@ type msgHeader struct {
 
 type MsgHeaderFactory struct {
 	pf mi.MsgPayloadFactory
+
+	codecs map[uint8]mi.MsgPayloadFactory
 }
 
-func NewMsgHeaderFactory(pf mi.MsgPayloadFactory) MsgFactory {
+func NewMsgHeaderFactory(pf mi.MsgPayloadFactory) *MsgHeaderFactory {
 	hf := new(MsgHeaderFactory)
 	hf.pf = pf
-	return MsgFactory(hf)
+	hf.codecs = make(map[uint8]mi.MsgPayloadFactory)
+	return hf
+}
+
+// RegisterCodec adds a codec which is chosen by WithCodec() on sender and
+// by the codec id of frame on receiver. It must be called before NewBuffer().
+func (hf *MsgHeaderFactory) RegisterCodec(id uint8, pf mi.MsgPayloadFactory) {
+	hf.codecs[id] = pf
 }
 
 func (hf *MsgHeaderFactory) NewBuffer() MsgBuffer {
@@ -39,6 +49,10 @@ func (hf *MsgHeaderFactory) NewBuffer() MsgBuffer {
 	hb.hdrlen = 24
 
 	hb.b = hf.pf.NewBuffer()
+	hb.codecs = make(map[uint8]mi.MsgPayloadBuffer)
+	for id, pf := range hf.codecs {
+		hb.codecs[id] = pf.NewBuffer()
+	}
 
 	return MsgBuffer(hb)
 }
@@ -47,6 +61,15 @@ type msgHeaderBuffer struct {
 	h      msgHeader
 	hdrlen uint32
 	b      mi.MsgPayloadBuffer
+
+	codecs map[uint8]mi.MsgPayloadBuffer
+}
+
+func (hb *msgHeaderBuffer) codec(id uint8) mi.MsgPayloadBuffer {
+	if id == CodecDefault {
+		return hb.b
+	}
+	return hb.codecs[id]
 }
 
 func (hb *msgHeaderBuffer) GetHdrLen() uint32 {
@@ -58,16 +81,27 @@ func (hb *msgHeaderBuffer) GetPayloadLen() uint32 {
 }
 
 func (hb *msgHeaderBuffer) MarshalPayload(p Payload, b []byte) ([]byte, error) {
+	hb.h.codec, p = unwrapCodec(p)
+
 	mp, ok := p.(mi.MsgPayload)
 	if !ok {
-		return b, nil
+		// zero length payload
+		return b[0:0], nil
 	}
 
-	return hb.b.Marshal(mp, b)
+	if c := hb.codec(hb.h.codec); c == nil {
+		return nil, ErrCodecUnknown
+	} else {
+		return c.Marshal(mp, b)
+	}
 }
 
 func (hb *msgHeaderBuffer) UnmarshalPayload(b []byte) (Payload, error) {
-	return hb.b.Unmarshal(hb.h.payload_id, b)
+	if c := hb.codec(hb.h.codec); c == nil {
+		return nil, ErrCodecUnknown
+	} else {
+		return c.Unmarshal(hb.h.payload_id, b)
+	}
 }
 
 func (hb *msgHeaderBuffer) SetPayloadInfo(p Payload) {
@@ -82,6 +116,7 @@ func (hb *msgHeaderBuffer) SetPayloadInfo(p Payload) {
 		if i.IsRequest() {
 			hb.h.flags |= MSG_REQUEST
 		}
+		hb.h.flags |= marshalFrameFlags(p)
 	}
 }
 
@@ -97,6 +132,7 @@ func (hb *msgHeaderBuffer) GetPayloadInfo(p Payload) {
 		if (hb.h.flags & MSG_REQUEST) == MSG_REQUEST {
 			i.SetIsRequest()
 		}
+		unmarshalFrameFlags(hb.h.flags, p)
 	}
 }
 
@@ -104,13 +140,11 @@ func (hb *msgHeaderBuffer) MarshalHeader(b []byte, p Payload, l uint32) error {
 	if uint32(len(b)) < hb.hdrlen {
 		return nil
 	}
-	mp, ok := p.(mi.MsgPayload)
-	if !ok {
-		return nil
+	// Set the payload_id, 0 means there is no payload.
+	_, p = unwrapCodec(p)
+	if mp, ok := p.(mi.MsgPayload); ok {
+		hb.h.payload_id = mp.GetMsgPayloadID()
 	}
-
-	// Set the payload_id
-	hb.h.payload_id = mp.GetMsgPayloadID()
 	// Set payload length
 	hb.h.payload_offset = uint16(hb.hdrlen)
 	// Set length
@@ -135,7 +169,7 @@ func (hb *msgHeaderBuffer) MarshalHeader(b []byte, p Payload, l uint32) error {
 	b[off+7] = byte(hb.h.rpcid)
 	off += 8
 
-	b[off] = byte(hb.h.version >> 8)
+	b[off] = byte(hb.h.codec)
 	b[off+1] = byte(hb.h.version)
 	off += 2
 
@@ -175,7 +209,8 @@ func (hb *msgHeaderBuffer) UnmarshalHeader(b []byte) error {
 	hb.h.rpcid |= uint64(b[off])<<24 | uint64(b[off+1])<<16 | uint64(b[off+2])<<8 | uint64(b[off+3])
 	off += 4
 
-	hb.h.version = uint16(b[off])<<8 | uint16(b[off+1])
+	hb.h.codec = uint8(b[off])
+	hb.h.version = uint8(b[off+1])
 	off += 2
 	hb.h.flags = uint16(b[off])<<8 | uint16(b[off+1])
 	off += 2
@@ -193,6 +228,7 @@ func (hb *msgHeaderBuffer) UnmarshalHeader(b []byte) error {
 func (hb *msgHeaderBuffer) Reset() {
 	hb.h.length = 0
 	hb.h.rpcid = 0
+	hb.h.codec = CodecDefault
 	hb.h.version = 1
 	hb.h.flags = 0
 	hb.h.payload_id = 0
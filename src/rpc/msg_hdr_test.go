@@ -4,6 +4,8 @@
 package rpc
 
 import (
+	"github.com/golang/protobuf/proto"
+	pbt "rpc/pb_test"
 	"testing"
 )
 
@@ -39,3 +41,62 @@ func TestHeaderFactory(t *testing.T) {
 		}
 	*/
 }
+
+func TestRPCHeaderTypedPayload(t *testing.T) {
+	pf := NewProtobufFactory()
+	pf.Register("rpc", func() Payload { return pbt.NewResourceReq() }, func() Payload { return pbt.NewResourceResp() })
+	hf := NewRPCHeaderFactory(pf)
+
+	w := hf.NewBuffer()
+	r := hf.NewBuffer()
+
+	out := new(routeMsg)
+	out.SetIsRPC()
+	out.SetIsRequest()
+	out.SetRPCID(7)
+	out.SetRPCName("rpc")
+
+	req := pbt.NewResourceReq()
+	req.Id = proto.Uint64(10000)
+
+	b := make([]byte, 1024)
+	w.Reset()
+	w.SetPayloadInfo(out)
+	hb := b[0:w.GetHdrLen()]
+	pb, err := w.MarshalPayload(req, b[w.GetHdrLen():])
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := w.MarshalHeader(hb, req, uint32(len(pb))); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	r.Reset()
+	if err := r.UnmarshalHeader(b); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if r.GetPayloadLen() != uint32(len(pb)) {
+		t.Log("payload length mismatch: ", r.GetPayloadLen(), len(pb))
+		t.FailNow()
+	}
+
+	p, err := r.UnmarshalPayload(b[r.GetHdrLen() : r.GetHdrLen()+r.GetPayloadLen()])
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if got, ok := p.(*pbt.ResourceReq); !ok || got.GetId() != 10000 {
+		t.Log("unexpected payload: ", p)
+		t.FailNow()
+	}
+
+	in := new(routeMsg)
+	r.GetPayloadInfo(in)
+	if in.GetRPCName() != "rpc" || in.GetRPCID() != 7 || !in.IsRequest() {
+		t.Log("unexpected rpc info: ", in)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"encoding/json"
+	mi "rpc/msg_interface"
+	"sync"
+)
+
+var (
+	ErrMsgPayloadUnknown error = &Error{err: "unknown msg payload id"}
+)
+
+// MsgNewFunc returns a new empty msg payload to decode into.
+type MsgNewFunc func() mi.MsgPayload
+
+// MsgJSONFactory is a MsgPayloadFactory which encodes payload as JSON, the
+// payload types are registered by payload id.
+type MsgJSONFactory struct {
+	mu    sync.RWMutex
+	types map[uint16]MsgNewFunc
+}
+
+func NewMsgJSONFactory() *MsgJSONFactory {
+	pf := new(MsgJSONFactory)
+	pf.types = make(map[uint16]MsgNewFunc)
+	return pf
+}
+
+func (pf *MsgJSONFactory) Register(id uint16, f MsgNewFunc) {
+	pf.mu.Lock()
+	pf.types[id] = f
+	pf.mu.Unlock()
+}
+
+func (pf *MsgJSONFactory) New(id uint16) mi.MsgPayload {
+	pf.mu.RLock()
+	f := pf.types[id]
+	pf.mu.RUnlock()
+
+	if f == nil {
+		return nil
+	}
+	return f()
+}
+
+func (pf *MsgJSONFactory) NewBuffer() mi.MsgPayloadBuffer {
+	jb := new(msgJSONBuffer)
+	jb.pf = pf
+
+	return mi.MsgPayloadBuffer(jb)
+}
+
+type msgJSONBuffer struct {
+	pf *MsgJSONFactory
+}
+
+func (jb *msgJSONBuffer) Marshal(p mi.MsgPayload, b []byte) ([]byte, error) {
+	return jsonMarshal(p, b)
+}
+
+func (jb *msgJSONBuffer) Unmarshal(id uint16, b []byte) (mi.MsgPayload, error) {
+	if id == 0 {
+		// zero length payload
+		return nil, nil
+	}
+
+	p := jb.pf.New(id)
+	if p == nil {
+		return nil, ErrMsgPayloadUnknown
+	}
+
+	if len(b) == 0 {
+		return p, nil
+	}
+
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
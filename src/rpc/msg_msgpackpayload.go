@@ -0,0 +1,81 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"reflect"
+	mi "rpc/msg_interface"
+	"sync"
+)
+
+// MsgMsgpackFactory is a MsgPayloadFactory which encodes payload as
+// MessagePack, the payload types are registered by payload id.
+type MsgMsgpackFactory struct {
+	mu    sync.RWMutex
+	types map[uint16]reflect.Type
+}
+
+func NewMsgMsgpackFactory() *MsgMsgpackFactory {
+	pf := new(MsgMsgpackFactory)
+	pf.types = make(map[uint16]reflect.Type)
+	return pf
+}
+
+// RegisterType registers the type of sample under sample.GetMsgPayloadID().
+func (pf *MsgMsgpackFactory) RegisterType(sample mi.MsgPayload) {
+	pf.mu.Lock()
+	pf.types[sample.GetMsgPayloadID()] = reflect.TypeOf(sample)
+	pf.mu.Unlock()
+}
+
+func (pf *MsgMsgpackFactory) New(id uint16) mi.MsgPayload {
+	pf.mu.RLock()
+	t, exist := pf.types[id]
+	pf.mu.RUnlock()
+
+	if !exist {
+		return nil
+	} else if t.Kind() == reflect.Ptr {
+		return reflect.New(t.Elem()).Interface().(mi.MsgPayload)
+	} else {
+		return reflect.Zero(t).Interface().(mi.MsgPayload)
+	}
+}
+
+func (pf *MsgMsgpackFactory) NewBuffer() mi.MsgPayloadBuffer {
+	mb := new(msgMsgpackBuffer)
+	mb.pf = pf
+
+	return mi.MsgPayloadBuffer(mb)
+}
+
+type msgMsgpackBuffer struct {
+	pf *MsgMsgpackFactory
+}
+
+func (mb *msgMsgpackBuffer) Marshal(p mi.MsgPayload, b []byte) ([]byte, error) {
+	return msgpackMarshal(b[0:0], p)
+}
+
+func (mb *msgMsgpackBuffer) Unmarshal(id uint16, b []byte) (mi.MsgPayload, error) {
+	if id == 0 {
+		// zero length payload
+		return nil, nil
+	}
+
+	p := mb.pf.New(id)
+	if p == nil {
+		return nil, ErrMsgPayloadUnknown
+	}
+
+	if len(b) == 0 {
+		return p, nil
+	}
+
+	if v, err := msgpackDecodeInto(b, p); err != nil {
+		return nil, err
+	} else {
+		return v.(mi.MsgPayload), nil
+	}
+}
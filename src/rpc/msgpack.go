@@ -0,0 +1,741 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// A small MessagePack(https://github.com/msgpack/msgpack/blob/master/spec.md)
+// implementation for schema-less payload. Structs are encoded as map of the
+// exported fields, the name can be changed by tag `msgpack:"name,omitempty"`.
+// Values decoded into interface{} use int64/uint64/float64/string/[]byte/
+// []interface{}/map[string]interface{}(or map[interface{}]interface{}).
+
+var (
+	ErrMsgpackShortBuffer  error = &Error{err: "msgpack short buffer"}
+	ErrMsgpackInvalidCode  error = &Error{err: "msgpack invalid code"}
+	ErrMsgpackUnsupported  error = &Error{err: "msgpack unsupported type"}
+	ErrMsgpackTypeMismatch error = &Error{err: "msgpack type mismatch"}
+	ErrMsgpackTooDeep      error = &Error{err: "msgpack nesting too deep"}
+	ErrMsgpackInvalidKey   error = &Error{err: "msgpack invalid map key"}
+)
+
+// msgpackMaxDepth is the max nesting level when encoding and decoding.
+const msgpackMaxDepth = 128
+
+const (
+	mpNil      = 0xc0
+	mpFalse    = 0xc2
+	mpTrue     = 0xc3
+	mpBin8     = 0xc4
+	mpBin16    = 0xc5
+	mpBin32    = 0xc6
+	mpFloat32  = 0xca
+	mpFloat64  = 0xcb
+	mpUint8    = 0xcc
+	mpUint16   = 0xcd
+	mpUint32   = 0xce
+	mpUint64   = 0xcf
+	mpInt8     = 0xd0
+	mpInt16    = 0xd1
+	mpInt32    = 0xd2
+	mpInt64    = 0xd3
+	mpStr8     = 0xd9
+	mpStr16    = 0xda
+	mpStr32    = 0xdb
+	mpArray16  = 0xdc
+	mpArray32  = 0xdd
+	mpMap16    = 0xde
+	mpMap32    = 0xdf
+	mpFixMap   = 0x80
+	mpFixArray = 0x90
+	mpFixStr   = 0xa0
+)
+
+type msgpackField struct {
+	name      string
+	index     int
+	omitempty bool
+}
+
+// cache of struct fields, map[reflect.Type][]msgpackField
+var msgpackFields sync.Map
+
+func msgpackStructFields(t reflect.Type) []msgpackField {
+	if v, ok := msgpackFields.Load(t); ok {
+		return v.([]msgpackField)
+	}
+
+	var fields []msgpackField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		f := msgpackField{name: sf.Name, index: i}
+		if tag := sf.Tag.Get("msgpack"); tag == "-" {
+			continue
+		} else if tag != "" {
+			opts := strings.Split(tag, ",")
+			if opts[0] != "" {
+				f.name = opts[0]
+			}
+			for _, o := range opts[1:] {
+				if o == "omitempty" {
+					f.omitempty = true
+				}
+			}
+		}
+		fields = append(fields, f)
+	}
+
+	msgpackFields.Store(t, fields)
+	return fields
+}
+
+func msgpackIsEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// msgpackMarshal appends the encoded v to b.
+func msgpackMarshal(b []byte, v interface{}) ([]byte, error) {
+	return msgpackAppend(b, reflect.ValueOf(v), 0)
+}
+
+func msgpackAppendUint(b []byte, code byte, v uint64, n int) []byte {
+	b = append(b, code)
+	for i := n - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(uint(i)*8)))
+	}
+	return b
+}
+
+func msgpackAppendLen(b []byte, fix byte, fixmax int, c8 byte, c16 byte, c32 byte, l int) []byte {
+	switch {
+	case l <= fixmax:
+		return append(b, fix|byte(l))
+	case c8 != 0 && l <= math.MaxUint8:
+		return msgpackAppendUint(b, c8, uint64(l), 1)
+	case l <= math.MaxUint16:
+		return msgpackAppendUint(b, c16, uint64(l), 2)
+	default:
+		return msgpackAppendUint(b, c32, uint64(l), 4)
+	}
+}
+
+func msgpackAppendInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return msgpackAppendUintValue(b, uint64(i))
+	case i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt8:
+		return msgpackAppendUint(b, mpInt8, uint64(i), 1)
+	case i >= math.MinInt16:
+		return msgpackAppendUint(b, mpInt16, uint64(i), 2)
+	case i >= math.MinInt32:
+		return msgpackAppendUint(b, mpInt32, uint64(i), 4)
+	default:
+		return msgpackAppendUint(b, mpInt64, uint64(i), 8)
+	}
+}
+
+func msgpackAppendUintValue(b []byte, u uint64) []byte {
+	switch {
+	case u <= 127:
+		return append(b, byte(u))
+	case u <= math.MaxUint8:
+		return msgpackAppendUint(b, mpUint8, u, 1)
+	case u <= math.MaxUint16:
+		return msgpackAppendUint(b, mpUint16, u, 2)
+	case u <= math.MaxUint32:
+		return msgpackAppendUint(b, mpUint32, u, 4)
+	default:
+		return msgpackAppendUint(b, mpUint64, u, 8)
+	}
+}
+
+// msgpackAppend appends the encoded v at nesting level depth, pointers count
+// as a level so that cyclic values are rejected.
+func msgpackAppend(b []byte, v reflect.Value, depth int) ([]byte, error) {
+	if !v.IsValid() {
+		return append(b, mpNil), nil
+	}
+	if depth >= msgpackMaxDepth {
+		return nil, ErrMsgpackTooDeep
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return append(b, mpNil), nil
+		}
+		return msgpackAppend(b, v.Elem(), depth+1)
+	case reflect.Interface:
+		if v.IsNil() {
+			return append(b, mpNil), nil
+		}
+		return msgpackAppend(b, v.Elem(), depth)
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, mpTrue), nil
+		}
+		return append(b, mpFalse), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return msgpackAppendInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return msgpackAppendUintValue(b, v.Uint()), nil
+	case reflect.Float32:
+		return msgpackAppendUint(b, mpFloat32, uint64(math.Float32bits(float32(v.Float()))), 4), nil
+	case reflect.Float64:
+		return msgpackAppendUint(b, mpFloat64, math.Float64bits(v.Float()), 8), nil
+	case reflect.String:
+		s := v.String()
+		b = msgpackAppendLen(b, mpFixStr, 31, mpStr8, mpStr16, mpStr32, len(s))
+		return append(b, s...), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return append(b, mpNil), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b = msgpackAppendLen(b, 0, -1, mpBin8, mpBin16, mpBin32, v.Len())
+			return append(b, v.Bytes()...), nil
+		}
+		fallthrough
+	case reflect.Array:
+		var err error
+		b = msgpackAppendLen(b, mpFixArray, 15, 0, mpArray16, mpArray32, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if b, err = msgpackAppend(b, v.Index(i), depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return append(b, mpNil), nil
+		}
+		var err error
+		b = msgpackAppendLen(b, mpFixMap, 15, 0, mpMap16, mpMap32, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			if b, err = msgpackAppend(b, iter.Key(), depth+1); err != nil {
+				return nil, err
+			}
+			if b, err = msgpackAppend(b, iter.Value(), depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Struct:
+		fields := msgpackStructFields(v.Type())
+		n := 0
+		for _, f := range fields {
+			if !f.omitempty || !msgpackIsEmpty(v.Field(f.index)) {
+				n++
+			}
+		}
+
+		var err error
+		b = msgpackAppendLen(b, mpFixMap, 15, 0, mpMap16, mpMap32, n)
+		for _, f := range fields {
+			fv := v.Field(f.index)
+			if f.omitempty && msgpackIsEmpty(fv) {
+				continue
+			}
+			b = msgpackAppendLen(b, mpFixStr, 31, mpStr8, mpStr16, mpStr32, len(f.name))
+			b = append(b, f.name...)
+			if b, err = msgpackAppend(b, fv, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+
+	return nil, ErrMsgpackUnsupported
+}
+
+// msgpackUnmarshal decodes b into v, v must be a non-nil pointer.
+func msgpackUnmarshal(b []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrMsgpackUnsupported
+	}
+
+	d := msgpackDecoder{b: b}
+	return d.decode(rv.Elem())
+}
+
+type msgpackDecoder struct {
+	b     []byte
+	off   int
+	depth int
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if d.off+n > len(d.b) {
+		return nil, ErrMsgpackShortBuffer
+	}
+	b := d.b[d.off : d.off+n]
+	d.off += n
+	return b, nil
+}
+
+func (d *msgpackDecoder) uint(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+func (d *msgpackDecoder) peek() (byte, error) {
+	if d.off >= len(d.b) {
+		return 0, ErrMsgpackShortBuffer
+	}
+	return d.b[d.off], nil
+}
+
+// length reads the length of str/bin/array/map.
+func (d *msgpackDecoder) length(c byte) (int, error) {
+	var n uint64
+	var err error
+
+	switch {
+	case c&0xe0 == mpFixStr:
+		n = uint64(c & 0x1f)
+	case c&0xf0 == mpFixArray, c&0xf0 == mpFixMap:
+		n = uint64(c & 0x0f)
+	case c == mpStr8, c == mpBin8:
+		n, err = d.uint(1)
+	case c == mpStr16, c == mpBin16, c == mpArray16, c == mpMap16:
+		n, err = d.uint(2)
+	case c == mpStr32, c == mpBin32, c == mpArray32, c == mpMap32:
+		n, err = d.uint(4)
+	default:
+		return 0, ErrMsgpackInvalidCode
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// every byte/element takes at least one byte, reject the length before
+	// allocating for it.
+	if n > uint64(len(d.b)-d.off) {
+		return 0, ErrMsgpackShortBuffer
+	}
+	return int(n), nil
+}
+
+// enter/leave track the nesting level.
+func (d *msgpackDecoder) enter() error {
+	if d.depth >= msgpackMaxDepth {
+		return ErrMsgpackTooDeep
+	}
+	d.depth++
+	return nil
+}
+
+func (d *msgpackDecoder) leave() {
+	d.depth--
+}
+
+func msgpackIsStr(c byte) bool {
+	return c&0xe0 == mpFixStr || c == mpStr8 || c == mpStr16 || c == mpStr32
+}
+
+func msgpackIsBin(c byte) bool {
+	return c == mpBin8 || c == mpBin16 || c == mpBin32
+}
+
+func msgpackIsArray(c byte) bool {
+	return c&0xf0 == mpFixArray || c == mpArray16 || c == mpArray32
+}
+
+func msgpackIsMap(c byte) bool {
+	return c&0xf0 == mpFixMap || c == mpMap16 || c == mpMap32
+}
+
+// number decodes int/uint/float, only one of the returns is meaningful
+// according to the kind.
+func (d *msgpackDecoder) number(c byte) (i int64, u uint64, f float64, k reflect.Kind, err error) {
+	switch {
+	case c <= 0x7f:
+		return 0, uint64(c), 0, reflect.Uint64, nil
+	case c >= 0xe0:
+		return int64(int8(c)), 0, 0, reflect.Int64, nil
+	case c == mpUint8:
+		u, err = d.uint(1)
+		k = reflect.Uint64
+	case c == mpUint16:
+		u, err = d.uint(2)
+		k = reflect.Uint64
+	case c == mpUint32:
+		u, err = d.uint(4)
+		k = reflect.Uint64
+	case c == mpUint64:
+		u, err = d.uint(8)
+		k = reflect.Uint64
+	case c == mpInt8:
+		u, err = d.uint(1)
+		i, k = int64(int8(u)), reflect.Int64
+	case c == mpInt16:
+		u, err = d.uint(2)
+		i, k = int64(int16(u)), reflect.Int64
+	case c == mpInt32:
+		u, err = d.uint(4)
+		i, k = int64(int32(u)), reflect.Int64
+	case c == mpInt64:
+		u, err = d.uint(8)
+		i, k = int64(u), reflect.Int64
+	case c == mpFloat32:
+		u, err = d.uint(4)
+		f, k = float64(math.Float32frombits(uint32(u))), reflect.Float64
+	case c == mpFloat64:
+		u, err = d.uint(8)
+		f, k = math.Float64frombits(u), reflect.Float64
+	default:
+		err = ErrMsgpackTypeMismatch
+	}
+	return
+}
+
+func (d *msgpackDecoder) decode(v reflect.Value) error {
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+
+	c, err := d.peek()
+	if err != nil {
+		return err
+	}
+
+	if c == mpNil {
+		d.off++
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return ErrMsgpackUnsupported
+		}
+		if i, err := d.value(); err != nil {
+			return err
+		} else if i != nil {
+			v.Set(reflect.ValueOf(i))
+		}
+		return nil
+	}
+
+	d.off++
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if c != mpTrue && c != mpFalse {
+			return ErrMsgpackTypeMismatch
+		}
+		v.SetBool(c == mpTrue)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, u, f, k, err := d.number(c)
+		if err != nil {
+			return err
+		}
+		switch k {
+		case reflect.Uint64:
+			i = int64(u)
+		case reflect.Float64:
+			i = int64(f)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, u, f, k, err := d.number(c)
+		if err != nil {
+			return err
+		}
+		switch k {
+		case reflect.Int64:
+			u = uint64(i)
+		case reflect.Float64:
+			u = uint64(f)
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		i, u, f, k, err := d.number(c)
+		if err != nil {
+			return err
+		}
+		switch k {
+		case reflect.Int64:
+			f = float64(i)
+		case reflect.Uint64:
+			f = float64(u)
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		if !msgpackIsStr(c) && !msgpackIsBin(c) {
+			return ErrMsgpackTypeMismatch
+		}
+		n, err := d.length(c)
+		if err != nil {
+			return err
+		}
+		b, err := d.next(n)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && (msgpackIsBin(c) || msgpackIsStr(c)) {
+			n, err := d.length(c)
+			if err != nil {
+				return err
+			}
+			b, err := d.next(n)
+			if err != nil {
+				return err
+			}
+			nb := make([]byte, n)
+			copy(nb, b)
+			v.SetBytes(nb)
+			return nil
+		}
+		if !msgpackIsArray(c) {
+			return ErrMsgpackTypeMismatch
+		}
+		n, err := d.length(c)
+		if err != nil {
+			return err
+		}
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := d.decode(s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		if !msgpackIsArray(c) {
+			return ErrMsgpackTypeMismatch
+		}
+		n, err := d.length(c)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if i < v.Len() {
+				err = d.decode(v.Index(i))
+			} else {
+				err = d.skip()
+			}
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if !msgpackIsMap(c) {
+			return ErrMsgpackTypeMismatch
+		}
+		n, err := d.length(c)
+		if err != nil {
+			return err
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), n))
+		}
+		kt, et := v.Type().Key(), v.Type().Elem()
+		for i := 0; i < n; i++ {
+			k := reflect.New(kt).Elem()
+			if err := d.decode(k); err != nil {
+				return err
+			}
+			if kt.Kind() == reflect.Interface && !k.IsNil() && !k.Elem().Type().Comparable() {
+				return ErrMsgpackInvalidKey
+			}
+			e := reflect.New(et).Elem()
+			if err := d.decode(e); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, e)
+		}
+	case reflect.Struct:
+		if !msgpackIsMap(c) {
+			return ErrMsgpackTypeMismatch
+		}
+		n, err := d.length(c)
+		if err != nil {
+			return err
+		}
+		fields := msgpackStructFields(v.Type())
+	nextField:
+		for i := 0; i < n; i++ {
+			var name string
+			if err := d.decode(reflect.ValueOf(&name).Elem()); err != nil {
+				return err
+			}
+			for _, f := range fields {
+				if f.name == name {
+					if err := d.decode(v.Field(f.index)); err != nil {
+						return err
+					}
+					continue nextField
+				}
+			}
+			// unknown field
+			if err := d.skip(); err != nil {
+				return err
+			}
+		}
+	default:
+		return ErrMsgpackUnsupported
+	}
+
+	return nil
+}
+
+// value decodes a generic value.
+func (d *msgpackDecoder) value() (interface{}, error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+
+	c, err := d.peek()
+	if err != nil {
+		return nil, err
+	}
+	d.off++
+
+	switch {
+	case c == mpNil:
+		return nil, nil
+	case c == mpTrue:
+		return true, nil
+	case c == mpFalse:
+		return false, nil
+	case msgpackIsStr(c):
+		n, err := d.length(c)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case msgpackIsBin(c):
+		n, err := d.length(c)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		nb := make([]byte, n)
+		copy(nb, b)
+		return nb, nil
+	case msgpackIsArray(c):
+		n, err := d.length(c)
+		if err != nil {
+			return nil, err
+		}
+		a := make([]interface{}, n)
+		for i := 0; i < n; i++ {
+			if a[i], err = d.value(); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	case msgpackIsMap(c):
+		n, err := d.length(c)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, n)
+		var im map[interface{}]interface{}
+		for i := 0; i < n; i++ {
+			k, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			e, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			if s, ok := k.(string); ok && im == nil {
+				m[s] = e
+				continue
+			}
+			// non-string key, switch to map[interface{}]interface{}
+			if k != nil && !reflect.TypeOf(k).Comparable() {
+				return nil, ErrMsgpackInvalidKey
+			}
+			if im == nil {
+				im = make(map[interface{}]interface{}, n)
+				for mk, mv := range m {
+					im[mk] = mv
+				}
+			}
+			im[k] = e
+		}
+		if im != nil {
+			return im, nil
+		}
+		return m, nil
+	}
+
+	i, u, f, k, err := d.number(c)
+	if err != nil {
+		return nil, err
+	}
+	switch k {
+	case reflect.Int64:
+		return i, nil
+	case reflect.Uint64:
+		return u, nil
+	default:
+		return f, nil
+	}
+}
+
+func (d *msgpackDecoder) skip() error {
+	_, err := d.value()
+	return err
+}
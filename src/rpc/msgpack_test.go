@@ -0,0 +1,203 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"reflect"
+	"testing"
+)
+
+const (
+	_ = iota
+	msgpackReqID
+	msgpackRespID
+)
+
+type msgpackReq struct {
+	Id     uint64            `msgpack:"id"`
+	Name   string            `msgpack:"name,omitempty"`
+	Tags   []string          `msgpack:"tags"`
+	Attrs  map[string]int64  `msgpack:"attrs"`
+	Data   []byte            `msgpack:"data"`
+	Score  float64           `msgpack:"score"`
+	Next   *msgpackReq       `msgpack:"next"`
+	Any    interface{}       `msgpack:"any"`
+	Ignore string            `msgpack:"-"`
+	Labels map[string]string `msgpack:"labels,omitempty"`
+}
+
+func (p *msgpackReq) GetMsgPayloadID() uint16 {
+	return msgpackReqID
+}
+
+type msgpackResp struct {
+	Id uint64 `msgpack:"id"`
+}
+
+func (p *msgpackResp) GetMsgPayloadID() uint16 {
+	return msgpackRespID
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	in := &msgpackReq{
+		Id:     1 << 40,
+		Name:   "msgpack",
+		Tags:   []string{"a", "bb"},
+		Attrs:  map[string]int64{"neg": -100000, "pos": 300},
+		Data:   []byte{0, 1, 2},
+		Score:  1.5,
+		Next:   &msgpackReq{Id: 2},
+		Any:    []interface{}{"x", int64(-1), uint64(7)},
+		Ignore: "ignored",
+	}
+
+	b, err := msgpackMarshal(nil, in)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	out := new(msgpackReq)
+	if err := msgpackUnmarshal(b, out); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	in.Ignore = ""
+	// fixint decodes as uint64 inside interface{}
+	in.Any = []interface{}{"x", int64(-1), uint64(7)}
+	if !reflect.DeepEqual(in, out) {
+		t.Log(in, out)
+		t.FailNow()
+	}
+
+	// schema-less
+	d := msgpackDecoder{b: b}
+	v, err := d.value()
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok || m["name"] != "msgpack" || m["id"] != uint64(1<<40) {
+		t.Log(v)
+		t.FailNow()
+	}
+	if _, exist := m["labels"]; exist {
+		t.Log("omitempty field is encoded")
+		t.FailNow()
+	}
+}
+
+func MsgpackProcessPayload(r *Router, name string, p Payload) Payload {
+	if req, ok := p.(*msgpackReq); ok {
+		return &msgpackResp{Id: req.Id}
+	} else if m, ok := p.(map[string]interface{}); ok {
+		// unregistered rpc
+		return m
+	} else {
+		panic("MsgpackProcessPayload receieve wrong info")
+	}
+}
+
+func TestMsgpackMalformed(t *testing.T) {
+	deep := make([]byte, 1000)
+	for i := range deep {
+		deep[i] = 0x91
+	}
+
+	for _, b := range [][]byte{
+		{0xdd, 0x7f, 0xff, 0xff, 0xff}, // array32 of 2G elements
+		{0xdf, 0x7f, 0xff, 0xff, 0xff}, // map32 of 2G entries
+		{0xc6, 0x7f, 0xff, 0xff, 0xff}, // bin32 of 2G bytes
+		{0x81, 0x91, 0x01, 0x01},       // array as map key
+		{0x81, 0x80, 0x01},             // map as map key
+		deep,
+	} {
+		var v interface{}
+		if err := msgpackUnmarshal(b, &v); err == nil {
+			t.Log(b)
+			t.FailNow()
+		}
+		var s []interface{}
+		if err := msgpackUnmarshal(b, &s); err == nil {
+			t.Log(b)
+			t.FailNow()
+		}
+		var m map[interface{}]interface{}
+		if err := msgpackUnmarshal(b, &m); err == nil {
+			t.Log(b)
+			t.FailNow()
+		}
+	}
+}
+
+func TestMsgpackEncodeDeep(t *testing.T) {
+	m := map[string]interface{}{}
+	m["m"] = m
+	s := []interface{}{nil}
+	s[0] = s
+	var p interface{}
+	p = &p
+
+	for _, v := range []interface{}{m, s, p} {
+		if _, err := msgpackMarshal(nil, v); err != ErrMsgpackTooDeep {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+
+	// not too deep
+	var v interface{} = "leaf"
+	for i := 0; i < msgpackMaxDepth-1; i++ {
+		v = []interface{}{v}
+	}
+	if _, err := msgpackMarshal(nil, v); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+}
+
+func TestRouterMsgpack(t *testing.T) {
+	r, err := NewRouter(nil, MsgpackProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+
+	pf := NewMsgpackFactory()
+	pf.RegisterType("rpc", &msgpackReq{}, &msgpackResp{})
+	hf := NewRPCHeaderFactory(pf)
+
+	name := "scheduler"
+	network := "tcp"
+	address := "localhost:10004"
+
+	r.Run()
+	defer r.Stop()
+
+	if err := r.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := r.Dial(name, network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if p, err := r.CallWait(name, "rpc", &msgpackReq{Id: 1}, 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if resp, ok := p.(*msgpackResp); !ok || resp.Id != 1 {
+		t.Log(p)
+		t.FailNow()
+	}
+
+	if p, err := r.CallWait(name, "echo", map[string]interface{}{"id": 2}, 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if m, ok := p.(map[string]interface{}); !ok || m["id"] != uint64(2) {
+		t.Log(p)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"strconv"
+)
+
+// Priority of rpc message, the higher one is routed and written first.
+type Priority uint8
+
+const (
+	PRIORITY_NORMAL Priority = iota
+	PRIORITY_HIGH
+	PRIORITY_HIGHER
+	PRIORITY_HIGHEST
+
+	PRIORITY_LEVELS = 4
+)
+
+const (
+	// The priority of the call, "0"-"3", it overrides SetPriority().
+	MetaPriority = "rpc-priority"
+)
+
+// PriorityInfo is implemented by the payload which carries a priority.
+type PriorityInfo interface {
+	GetPriority() Priority
+	SetPriority(Priority)
+}
+
+// PriorityChannel is implemented by IOChannel which queues payloads by
+// priority.
+type PriorityChannel interface {
+	// InQueue returns the In() channel of payload p.
+	InQueue(p Payload) chan Payload
+	// OutQueue returns the Out() channel of priority, nil means Out().
+	OutQueue(Priority) chan Payload
+}
+
+// SetPriority sets the default priority of rpc calls. It should be called
+// before Run().
+func (r *Router) SetPriority(rpc string, prio Priority) {
+	if prio >= PRIORITY_LEVELS {
+		prio = PRIORITY_LEVELS - 1
+	}
+	r.priorities[rpc] = prio
+}
+
+// SetPriorityWeights sets the scheduling between priority queues of Router
+// and new EndPoints. nil is strict: the higher priority queue is always
+// drained first. Otherwise weights[i] messages of priority i are taken per
+// round, the missing or non-positive weight is 1. It should be called before
+// Run().
+func (r *Router) SetPriorityWeights(weights []int) {
+	r.weights = weights
+}
+
+// priority returns the priority of call rpc with metadata md.
+func (r *Router) priority(rpc string, md Metadata) Priority {
+	if v, exist := md[MetaPriority]; exist {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			if n >= PRIORITY_LEVELS {
+				n = PRIORITY_LEVELS - 1
+			}
+			return Priority(n)
+		}
+	}
+	return r.priorities[rpc]
+}
+
+// outQueue returns the router out channel of m.
+func (r *Router) outQueue(m *routeMsg) chan Payload {
+	return r.outs[m.prio]
+}
+
+// prioScheduler picks the next payload from priority queues, it is not
+// thread safe.
+type prioScheduler struct {
+	weights []int // nil is strict
+	credits []int
+}
+
+func newPrioScheduler(weights []int) *prioScheduler {
+	s := new(prioScheduler)
+	if weights == nil {
+		return s
+	}
+
+	s.weights = make([]int, PRIORITY_LEVELS)
+	for i := range s.weights {
+		if i < len(weights) && weights[i] > 0 {
+			s.weights[i] = weights[i]
+		} else {
+			s.weights[i] = 1
+		}
+	}
+	s.credits = make([]int, PRIORITY_LEVELS)
+	copy(s.credits, s.weights)
+	return s
+}
+
+func tryRecv(ch chan Payload) (Payload, bool) {
+	select {
+	case p := <-ch:
+		return p, true
+	default:
+		return nil, false
+	}
+}
+
+// next returns the payload of the highest priority queue which has credits,
+// it does not wait.
+func (s *prioScheduler) next(qs *[PRIORITY_LEVELS]chan Payload) (Payload, bool) {
+	if s == nil || s.weights == nil {
+		for i := PRIORITY_LEVELS - 1; i >= 0; i-- {
+			if p, ok := tryRecv(qs[i]); ok {
+				return p, true
+			}
+		}
+		return nil, false
+	}
+
+	for round := 0; round < 2; round++ {
+		for i := PRIORITY_LEVELS - 1; i >= 0; i-- {
+			if s.credits[i] <= 0 {
+				continue
+			}
+			if p, ok := tryRecv(qs[i]); ok {
+				s.credits[i]--
+				return p, true
+			}
+		}
+		// The queues with credits are empty, start a new round.
+		copy(s.credits, s.weights)
+	}
+	return nil, false
+}
+
+func (rm *routeMsg) GetPriority() Priority {
+	return rm.prio
+}
+
+func (rm *routeMsg) SetPriority(prio Priority) {
+	rm.prio = prio
+}
@@ -0,0 +1,156 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestPriorityScheduler(t *testing.T) {
+	fill := func() *[PRIORITY_LEVELS]chan Payload {
+		var qs [PRIORITY_LEVELS]chan Payload
+		for i := range qs {
+			qs[i] = make(chan Payload, 4)
+			for j := 0; j < 4; j++ {
+				qs[i] <- i
+			}
+		}
+		return &qs
+	}
+	order := func(s *prioScheduler, qs *[PRIORITY_LEVELS]chan Payload, n int) []int {
+		var o []int
+		for i := 0; i < n; i++ {
+			p, ok := s.next(qs)
+			if !ok {
+				break
+			}
+			o = append(o, p.(int))
+		}
+		return o
+	}
+	check := func(got []int, expect []int) {
+		if len(got) != len(expect) {
+			t.Log(got)
+			t.FailNow()
+		}
+		for i := range got {
+			if got[i] != expect[i] {
+				t.Log(got)
+				t.FailNow()
+			}
+		}
+	}
+
+	// strict
+	check(order(newPrioScheduler(nil), fill(), 6), []int{3, 3, 3, 3, 2, 2})
+
+	// weighted
+	check(order(newPrioScheduler([]int{1, 1, 1, 2}), fill(), 10), []int{3, 3, 2, 1, 0, 3, 3, 2, 1, 0})
+
+	// drained
+	qs := fill()
+	check(order(newPrioScheduler([]int{1, 1, 1, 2}), qs, 32)[14:], []int{1, 0})
+	if _, ok := newPrioScheduler(nil).next(qs); ok {
+		t.FailNow()
+	}
+}
+
+func TestRPCHeaderPriority(t *testing.T) {
+	hf := NewRPCHeaderFactory(NewJSONFactory())
+
+	w := hf.NewBuffer()
+	r := hf.NewBuffer()
+
+	out := new(routeMsg)
+	out.SetIsRPC()
+	out.SetRPCID(7)
+	out.SetRPCName("rpc")
+	out.SetPriority(PRIORITY_HIGHER)
+
+	b := make([]byte, 1024)
+	w.Reset()
+	w.SetPayloadInfo(out)
+	pb, err := w.MarshalPayload(nil, b[w.GetHdrLen():])
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := w.MarshalHeader(b[0:w.GetHdrLen()], nil, uint32(len(pb))); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	r.Reset()
+	if err := r.UnmarshalHeader(b); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	in := new(routeMsg)
+	r.GetPayloadInfo(in)
+	if in.GetPriority() != PRIORITY_HIGHER || !in.IsReply() || in.GetRPCID() != 7 {
+		t.Log("unexpected rpc info: ", in)
+		t.FailNow()
+	}
+}
+
+func TestRouterPriority(t *testing.T) {
+	pf := NewJSONFactory()
+	hf := NewRPCHeaderFactory(pf)
+
+	network := "tcp"
+	address := "localhost:10019"
+
+	server, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	expect := func(prio Priority) ServeRPC {
+		return func(ctx *RPCContext, p Payload) (Payload, error) {
+			if ctx.Priority != prio {
+				return nil, NewStatusError(STATUS_INVALID_ARGUMENT, strconv.Itoa(int(ctx.Priority)))
+			}
+			return p, nil
+		}
+	}
+	server.HandleRPC("normal", expect(PRIORITY_NORMAL))
+	server.HandleRPC("high", expect(PRIORITY_HIGH))
+	server.HandleRPC("highest", expect(PRIORITY_HIGHEST))
+	server.SetPriorityWeights([]int{1, 2, 4, 8})
+	server.Run()
+	defer server.Stop()
+
+	client, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	client.SetPriority("high", PRIORITY_HIGH)
+	client.Run()
+	defer client.Stop()
+
+	if err := server.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := client.Dial("server", network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	for i := 0; i < 16; i++ {
+		if _, err := client.CallWait("server", "normal", nil, 5); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if _, err := client.CallWait("server", "high", nil, 5); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if _, err := client.CallWait("server", "highest", WithMetadata(Metadata{MetaPriority: "3"}, nil), 5); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"sync/atomic"
+)
+
+var (
+	ErrTopicEmpty error = &Error{err: "Topic is empty"}
+)
+
+// TopicInfo is implemented by the rpc payload which can be SUBSCRIBE,
+// UNSUBSCRIBE or PUBLISH frame. The topic is carried as rpc name.
+type TopicInfo interface {
+	GetTopicOP() uint16
+	SetTopicOP(uint16)
+}
+
+// Subscription receives the messages published to topic by this Router or
+// the subscribed EndPoints. The message is dropped if C is full.
+type Subscription struct {
+	r     *Router
+	topic string
+
+	C chan Payload
+
+	dropped uint64
+}
+
+func (s *Subscription) GetTopic() string {
+	return s.topic
+}
+
+// Dropped returns the number of messages dropped because C is full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Unsubscribe stops the delivery, C is closed.
+func (s *Subscription) Unsubscribe() error {
+	if _, err := s.r.requestOP(RouterOPUnsubscribe, s); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Subscribe subscribes topic locally, n is the queue limit(0 means 1024).
+// The payload is shared by all subscribers, it should not be modified.
+func (r *Router) Subscribe(topic string, n int) (*Subscription, error) {
+	if topic == "" {
+		return nil, ErrTopicEmpty
+	}
+
+	if n <= 0 {
+		n = 1024
+	}
+
+	s := new(Subscription)
+	s.r = r
+	s.topic = topic
+	s.C = make(chan Payload, n)
+
+	if _, err := r.requestOP(RouterOPSubscribe, s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// SubscribeEndPoint asks the peer of ep to forward messages of topic.
+func (r *Router) SubscribeEndPoint(ep string, topic string) error {
+	return r.topic(ep, topic, FRAME_SUBSCRIBE, nil)
+}
+
+func (r *Router) UnsubscribeEndPoint(ep string, topic string) error {
+	return r.topic(ep, topic, FRAME_UNSUBSCRIBE, nil)
+}
+
+// Publish sends p to all local subscribers and EndPoints subscribed topic.
+func (r *Router) Publish(topic string, p Payload) error {
+	return r.topic("", topic, FRAME_PUBLISH, p)
+}
+
+// SetPublishQueueLimit sets the max pending messages of subscribed EndPoint,
+// the published message is dropped if the limit is reached.
+func (r *Router) SetPublishQueueLimit(n int) {
+	r.publimit = n
+}
+
+func (r *Router) topic(ep string, topic string, op uint16, p Payload) error {
+	if topic == "" {
+		return ErrTopicEmpty
+	}
+
+	if ep != "" {
+		if v, err := r.requestOP(RouterOPTopicSupported, ep); err != nil {
+			return err
+		} else if v != nil {
+			return v.(error)
+		}
+	}
+
+	var out *routeMsg
+	if v := r.clientOutMsgs.Get(); v == nil {
+		return ErrOPRouterStopped
+	} else {
+		out = v.(*routeMsg)
+	}
+
+	out.Reset()
+	out.ep_name = ep
+	out.rpc = topic
+	out.is_rpc = true
+	out.topic_op = op
+
+	out.p = p
+
+	out.cb = serve_done
+	out.r = r
+
+	select {
+	case r.outQueue(out) <- out:
+	default:
+		panic("routeMsg leaks?!")
+	}
+
+	return nil
+}
+
+func (r *Router) subscribe(s *Subscription) {
+	subs, exist := r.topicSubs[s.topic]
+	if !exist {
+		subs = make(map[*Subscription]bool)
+		r.topicSubs[s.topic] = subs
+	}
+	subs[s] = true
+}
+
+func (r *Router) unsubscribe(s *Subscription) {
+	if subs, exist := r.topicSubs[s.topic]; exist && subs[s] {
+		delete(subs, s)
+		if len(subs) == 0 {
+			delete(r.topicSubs, s.topic)
+		}
+		close(s.C)
+	}
+}
+
+func (r *Router) unsubscribeEndPoint(name string) {
+	for topic, eps := range r.topicEPs {
+		delete(eps, name)
+		if len(eps) == 0 {
+			delete(r.topicEPs, topic)
+		}
+	}
+}
+
+func (r *Router) publishLocal(topic string, p Payload) {
+	for s := range r.topicSubs[topic] {
+		select {
+		case s.C <- p:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+			r.stats.pubDrop++
+		}
+	}
+}
+
+// PublishOut fans m out, the subscribed EndPoint is skipped if its queue
+// limit is reached.
+func (r *Router) PublishOut(m *routeMsg) {
+	r.stats.pubOut++
+
+	r.publishLocal(m.rpc, m.p)
+
+	for name := range r.topicEPs[m.rpc] {
+		ep, exist := r.nmap[name]
+		if !exist {
+			continue
+		}
+
+		if len(ep.out) >= r.publimit {
+			r.stats.pubDrop++
+			continue
+		}
+
+		// never wait inside router goroutine
+		var out *routeMsg
+		if v := r.pubMsgs.TryGet(); v == nil {
+			r.stats.pubDrop++
+			continue
+		} else {
+			out = v.(*routeMsg)
+		}
+
+		out.Reset()
+		out.ep_name = name
+		out.rpc = m.rpc
+		out.is_rpc = true
+		out.topic_op = FRAME_PUBLISH
+		out.p = m.p
+		out.r = r
+
+		if err := ep.write(out); err != nil {
+			r.stats.msgError++
+			out.Recycle()
+		}
+	}
+}
+
+func (r *Router) TopicIn(m *routeMsg) {
+	if r.auth != nil {
+		// the topic frames are not authenticated per message.
+		if m.topic_op == FRAME_PUBLISH {
+			r.stats.pubDrop++
+		}
+		return
+	}
+
+	switch m.topic_op {
+	case FRAME_SUBSCRIBE:
+		eps, exist := r.topicEPs[m.rpc]
+		if !exist {
+			eps = make(map[string]bool)
+			r.topicEPs[m.rpc] = eps
+		}
+		eps[m.ep_name] = true
+	case FRAME_UNSUBSCRIBE:
+		if eps, exist := r.topicEPs[m.rpc]; exist {
+			delete(eps, m.ep_name)
+			if len(eps) == 0 {
+				delete(r.topicEPs, m.rpc)
+			}
+		}
+	case FRAME_PUBLISH:
+		// TODO: forward to other EndPoints?
+		r.stats.pubIn++
+		r.publishLocal(m.rpc, m.p)
+	}
+}
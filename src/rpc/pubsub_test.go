@@ -0,0 +1,113 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRouterPublish(t *testing.T) {
+	pf := NewJSONFactory()
+	pf.Register("news", nil, func() Payload { return new(jsonResp) })
+	hf := NewRPCHeaderFactory(pf)
+
+	network := "tcp"
+	address := "localhost:10010"
+
+	server, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	server.Run()
+	defer server.Stop()
+
+	client, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	client.Run()
+	defer client.Stop()
+
+	if err := server.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := client.Dial("server", network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	local, err := server.Subscribe("news", 0)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	remote, err := client.Subscribe("news", 0)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := client.SubscribeEndPoint("server", "news"); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// the subscription is asynchronous, publish until the client receives.
+	var id uint64
+	for received := false; !received; {
+		id++
+		if err := server.Publish("news", &jsonResp{Id: id}); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+
+		if p := <-local.C; p.(*jsonResp).Id != id {
+			t.Log(p)
+			t.FailNow()
+		}
+
+		select {
+		case p := <-remote.C:
+			if p.(*jsonResp).Id > id {
+				t.Log(p)
+				t.FailNow()
+			}
+			received = true
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		if id > 50 {
+			t.Log("subscription does not work")
+			t.FailNow()
+		}
+	}
+
+	// queue limit
+	limited, err := server.Subscribe("news", 1)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	for i := 0; i < 10; i++ {
+		server.Publish("news", &jsonResp{Id: uint64(i)})
+	}
+	for i := 0; i < 10; i++ {
+		<-local.C
+	}
+	if n := limited.Dropped(); n != 9 {
+		t.Log(n)
+		t.FailNow()
+	}
+
+	if err := limited.Unsubscribe(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	<-limited.C
+	if _, ok := <-limited.C; ok {
+		t.Log("Unsubscribe does not close C")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	mi "rpc/msg_interface"
+)
+
+type rawFactory struct {
+	*RPCTypeRegistry
+}
+
+// NewRawFactory returns a RPCPayloadFactory which passes []byte through, the
+// registered types are ignored.
+func NewRawFactory() RPCPayloadFactory {
+	pf := new(rawFactory)
+	pf.RPCTypeRegistry = NewRPCTypeRegistry()
+	return RPCPayloadFactory(pf)
+}
+
+func (pf *rawFactory) NewBuffer() RPCPayloadBuffer {
+	return RPCPayloadBuffer(rawBuffer{})
+}
+
+type rawBuffer struct{}
+
+func (rb rawBuffer) Marshal(p Payload, b []byte) ([]byte, error) {
+	if p == nil {
+		return b[0:0], nil
+	}
+
+	raw, ok := p.([]byte)
+	if !ok {
+		return nil, ErrRPCPayloadType
+	}
+
+	return append(b[0:0], raw...), nil
+}
+
+func (rb rawBuffer) Unmarshal(name string, request bool, b []byte) (Payload, error) {
+	nb := make([]byte, len(b))
+	copy(nb, b)
+	return nb, nil
+}
+
+// RawMsg is the msg payload of NewMsgRawFactory.
+type RawMsg struct {
+	ID uint16
+	B  []byte
+}
+
+func (m *RawMsg) GetMsgPayloadID() uint16 {
+	return m.ID
+}
+
+type msgRawFactory struct{}
+
+// NewMsgRawFactory returns a MsgPayloadFactory which passes *RawMsg through.
+func NewMsgRawFactory() mi.MsgPayloadFactory {
+	return mi.MsgPayloadFactory(msgRawFactory{})
+}
+
+func (pf msgRawFactory) NewBuffer() mi.MsgPayloadBuffer {
+	return mi.MsgPayloadBuffer(msgRawBuffer{})
+}
+
+type msgRawBuffer struct{}
+
+func (rb msgRawBuffer) Marshal(p mi.MsgPayload, b []byte) ([]byte, error) {
+	raw, ok := p.(*RawMsg)
+	if !ok {
+		return nil, ErrRPCPayloadType
+	}
+
+	return append(b[0:0], raw.B...), nil
+}
+
+func (rb msgRawBuffer) Unmarshal(id uint16, b []byte) (mi.MsgPayload, error) {
+	if id == 0 {
+		// zero length payload
+		return nil, nil
+	}
+
+	m := &RawMsg{ID: id, B: make([]byte, len(b))}
+	copy(m.B, b)
+	return m, nil
+}
@@ -43,6 +43,12 @@ type Reader struct {
 	hb   []byte
 	pb   []byte
 
+	// fragmented messages in progress, fragment id -> received chunks
+	frags  map[uint64][]byte
+	maxmsg int
+
+	local frameConn // passes messages instead of bytes
+
 	stats  iostats
 	logger *log.Logger
 }
@@ -58,6 +64,7 @@ func NewReader(conn io.ReadCloser, io IOChannel, mb MsgBuffer, logger *log.Logge
 
 	r.conn = conn
 	r.mb = mb
+	r.local, _ = conn.(frameConn)
 	r.maxlen = 128 * 1024
 
 	r.buffered = true
@@ -74,9 +81,22 @@ func NewReader(conn io.ReadCloser, io IOChannel, mb MsgBuffer, logger *log.Logge
 
 	r.step = header_init
 
+	r.frags = make(map[uint64][]byte)
+	r.SetMaxMessageSize(0)
+
 	return r
 }
 
+// SetMaxMessageSize sets the max size of message reassembled from
+// CONTINUATION frames, the larger one closes the connection. 0 means the
+// default(256MB).
+func (r *Reader) SetMaxMessageSize(n int) {
+	if n <= 0 {
+		n = defaultMaxMessageSize
+	}
+	r.maxmsg = n
+}
+
 func (r *Reader) Read() (Payload, error) {
 	// TODO: not implement
 	return nil, nil
@@ -112,7 +132,7 @@ func (r *Reader) Loop(q chan struct{}) {
 		}
 
 		select {
-		case r.io.In() <- r.p:
+		case r.inQueue(r.p) <- r.p:
 			r.p = nil
 		case <-q:
 			break
@@ -120,6 +140,13 @@ func (r *Reader) Loop(q chan struct{}) {
 	}
 }
 
+func (r *Reader) inQueue(p Payload) chan Payload {
+	if pc, ok := r.io.(PriorityChannel); ok {
+		return pc.InQueue(p)
+	}
+	return r.io.In()
+}
+
 func (r *Reader) read(b []byte) (int, error) {
 	//	if r.use_lbuf {
 	// TODO
@@ -131,28 +158,37 @@ func (r *Reader) read(b []byte) (int, error) {
 		return len(b), nil
 	}
 
-	// TODO: set deadline
-	needlen := r.maxlen
-	if !r.buffered {
-		needlen = aoff - doff
-	}
+	// Read until the request is satisfied, a frame may be splitted into
+	// several conn.Read().
+	for {
+		// TODO: set deadline
+		needlen := r.maxlen
+		if !r.buffered {
+			needlen = aoff - doff
+		}
+		if doff+needlen > len(r.b) {
+			needlen = len(r.b) - doff
+		}
 
-	n, err := r.conn.Read(r.b[doff : doff+needlen])
-	if n < 0 {
-		return n, err
-	}
-	r.stats.Bytes += uint64(n)
-	r.stats.Times += 1
+		n, err := r.conn.Read(r.b[doff : doff+needlen])
+		if n < 0 {
+			return n, err
+		}
+		r.stats.Bytes += uint64(n)
+		r.stats.Times += 1
 
-	r.b_data_offset += n
-	doff += n
+		r.b_data_offset += n
+		doff += n
 
-	if aoff <= doff {
-		// ignore err if satisify request.
-		return len(b), nil
-	}
+		if aoff <= doff {
+			// ignore err if satisify request.
+			return len(b), nil
+		}
 
-	return n, err
+		if err != nil {
+			return n, err
+		}
+	}
 }
 
 func (r *Reader) allocBuf(rlen uint32) []byte {
@@ -183,6 +219,10 @@ func (r *Reader) allocBuf(rlen uint32) []byte {
 }
 
 func (r *Reader) LoopOnce(q chan struct{}) (Payload, error) {
+	if r.local != nil {
+		return r.receive()
+	}
+
 	for {
 		switch r.step {
 		case header_init:
@@ -206,7 +246,9 @@ func (r *Reader) LoopOnce(q chan struct{}) (Payload, error) {
 				r.pb = r.allocBuf(plen)
 				r.step = body_read
 			} else {
-				// TODO: no payload ?
+				// zero length payload, nothing to read.
+				r.pb = r.pb[:0]
+				r.step = body_unmarshal
 			}
 		case body_read:
 			// TODO: enlarge b []byte if plen > r.maxlen or error out.
@@ -218,6 +260,25 @@ func (r *Reader) LoopOnce(q chan struct{}) (Payload, error) {
 			}
 			r.step = body_unmarshal
 		case body_unmarshal:
+			if id, cont, end := r.continuation(); cont {
+				r.step = header_init
+				b, ok := r.frags[id]
+				if !ok && len(r.frags) >= maxFragments {
+					return nil, ErrTooManyFragments
+				} else if len(b)+len(r.pb) > r.maxmsg {
+					return nil, ErrFragmentTooLarge
+				}
+				// copy the chunk, r.pb will be reused.
+				r.frags[id] = append(b, r.pb...)
+				if !end {
+					continue
+				}
+
+				b = r.frags[id]
+				delete(r.frags, id)
+				return r.unmarshalFragments(b)
+			}
+
 			if p, err := r.mb.UnmarshalPayload(r.pb); err != nil {
 				// invalid message
 				return nil, err
@@ -230,3 +291,36 @@ func (r *Reader) LoopOnce(q chan struct{}) (Payload, error) {
 		}
 	}
 }
+
+func (r *Reader) continuation() (uint64, bool, bool) {
+	if fb, ok := r.mb.(FrameBuffer); ok {
+		return fb.GetContinuation()
+	}
+	return 0, false, false
+}
+
+// unmarshalFragments decodes the message reassembled from CONTINUATION frames.
+func (r *Reader) unmarshalFragments(b []byte) (Payload, error) {
+	r.mb.Reset()
+
+	hlen := r.mb.GetHdrLen()
+	if uint32(len(b)) < hlen {
+		return nil, errShortRead
+	}
+	if err := r.mb.UnmarshalHeader(b[0:hlen]); err != nil {
+		return nil, err
+	}
+
+	plen := r.mb.GetPayloadLen()
+	if uint32(len(b)) < hlen+plen {
+		return nil, errShortRead
+	}
+
+	if p, err := r.mb.UnmarshalPayload(b[hlen : hlen+plen]); err != nil {
+		return nil, err
+	} else {
+		p = r.io.Wrap(p)
+		r.mb.GetPayloadInfo(p)
+		return p, nil
+	}
+}
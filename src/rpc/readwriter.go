@@ -8,6 +8,18 @@ import ()
 var (
 	errQuit      error = &Error{err: "quit"}
 	errShortRead error = &Error{err: "short read"}
+
+	ErrFragmentTooLarge error = &Error{err: "fragmented message too large"}
+	ErrTooManyFragments error = &Error{err: "too many fragmented messages"}
+	ErrMessageTooLarge  error = &Error{err: "message too large"}
+)
+
+const (
+	// the default max size of message, the larger one is not sent and the
+	// peer sending it is closed(see Router.SetMaxMessageSize).
+	defaultMaxMessageSize = 256 * 1024 * 1024
+	// the max number of fragmented messages in progress per connection.
+	maxFragments = 64
 )
 
 // IOChannel
@@ -23,6 +35,12 @@ type IOChannel interface {
 	OutError(error)
 }
 
+// msgErrorChannel is implemented by the IOChannel which fails the message
+// dropped by Writer instead of the whole channel, mb holds the header of it.
+type msgErrorChannel interface {
+	OutMsgError(mb MsgBuffer, err error)
+}
+
 /*
 type Encoder interface {
 	GetHdrLen() uint32
@@ -0,0 +1,147 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"strconv"
+	"time"
+)
+
+// MetaAttempt is the metadata key of attempt number(2, 3...) of the retried
+// request.
+const MetaAttempt = "rpc-attempt"
+
+// RetryPolicy decides whether the failed call is sent again. It is applied to
+// idempotent rpc only.
+type RetryPolicy struct {
+	// Max attempts including the first one.
+	MaxAttempts int
+	// Backoff of the first retry, it is doubled by every retry.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Retryable status, STATUS_UNAVAILABLE if it is empty.
+	Codes []Status
+}
+
+func (rp *RetryPolicy) retryable(code Status) bool {
+	if len(rp.Codes) == 0 {
+		return code == STATUS_UNAVAILABLE
+	}
+
+	for _, c := range rp.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (rp *RetryPolicy) backoff(attempt int) time.Duration {
+	d := rp.Backoff
+	for i := 0; i < attempt && (rp.MaxBackoff <= 0 || d < rp.MaxBackoff); i++ {
+		d *= 2
+	}
+	if rp.MaxBackoff > 0 && d > rp.MaxBackoff {
+		d = rp.MaxBackoff
+	}
+	return d
+}
+
+// SetRetryPolicy sets the retry policy of rpc, nil disables retry. It should
+// be called before Run().
+func (r *Router) SetRetryPolicy(rpc string, rp *RetryPolicy) {
+	if rp == nil {
+		delete(r.retries, rpc)
+	} else {
+		r.retries[rpc] = rp
+	}
+}
+
+// SetIdempotent marks rpc as idempotent, only idempotent rpc is retried. It
+// should be called before Run().
+func (r *Router) SetIdempotent(rpc string) {
+	r.idempotent[rpc] = true
+}
+
+// retry schedules failed request m after backoff, m is owned by router if it
+// returns true.
+func (r *Router) retry(m *routeMsg, err error) bool {
+	rp, exist := r.retries[m.rpc]
+	if !exist || !r.idempotent[m.rpc] {
+		return false
+	}
+
+	if m.attempt+1 >= rp.MaxAttempts || !rp.retryable(StatusOf(err)) {
+		return false
+	}
+
+	at := time.Now().Add(rp.backoff(m.attempt))
+	if !at.Before(m.to) {
+		// no time left
+		return false
+	}
+
+	m.attempt++
+	m.retry_at = at
+
+	// sign again, the server rejects the reused credentials.
+	if md, err := r.credentials(m.ep_name, m.rpc, m.p, m.md); err != nil {
+		return false
+	} else {
+		m.md = md
+	}
+
+	// the server sees the attempt number
+	m.md = m.md.with(MetaAttempt, strconv.Itoa(m.attempt+1))
+
+	// keep it in calls, so it can be canceled.
+	r.calls[m.id] = m
+	r.stats.rpcRetry++
+
+	if tid, err := r.tt.Add(m); err == ErrTimeout {
+		// backoff is shorter than the tick, it is already sent again.
+	} else if err != nil {
+		delete(r.calls, m.id)
+		m.retry_at = time.Time{}
+		return false
+	} else {
+		m.tid = tid
+	}
+
+	return true
+}
+
+// resend sends m again, it runs inside TimeoutTracker.
+func (r *Router) resend(m *routeMsg) {
+	delete(r.calls, m.id)
+	m.retry_at = time.Time{}
+	m.tid = InvalidTrackID
+
+	select {
+	case r.outQueue(m) <- m:
+	default:
+		panic("routeMsg leaks?!")
+	}
+}
+
+// failEndPointCalls fails the calls in progress of EndPoint name.
+func (r *Router) failEndPointCalls(name string) {
+	for id, out := range r.calls {
+		m := out.(*routeMsg)
+		if m.ep_name != name || !m.retry_at.IsZero() {
+			continue
+		}
+
+		delete(r.calls, id)
+		r.tt.Del(m.tid)
+		r.breakerDone(m, ErrEndPointClosed)
+		r.limitRelease(m)
+
+		if !r.retry(m, ErrEndPointClosed) {
+			cb, arg := m.cb, m.arg
+			go cb(nil, arg, ErrEndPointClosed)
+			m.Recycle()
+		}
+	}
+}
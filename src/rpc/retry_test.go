@@ -0,0 +1,128 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"testing"
+	"time"
+)
+
+func FlakyServeRPC(ctx *RPCContext, p Payload) (Payload, error) {
+	if ctx.Metadata[MetaAttempt] != "3" {
+		return nil, NewStatusError(STATUS_UNAVAILABLE, "try again")
+	}
+	return &jsonResp{Id: p.(*jsonReq).Id}, nil
+}
+
+func TestRouterRetry(t *testing.T) {
+	pf := NewJSONFactory()
+	for _, rpc := range []string{"flaky", "unsafe"} {
+		pf.Register(rpc, func() Payload { return new(jsonReq) }, func() Payload { return new(jsonResp) })
+	}
+	hf := NewRPCHeaderFactory(pf)
+
+	network := "tcp"
+	address := "localhost:10012"
+
+	server, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	server.HandleRPC("flaky", FlakyServeRPC)
+	server.HandleRPC("unsafe", FlakyServeRPC)
+	server.Run()
+	defer server.Stop()
+
+	client, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	rp := &RetryPolicy{MaxAttempts: 3, Backoff: 10 * time.Millisecond}
+	client.SetRetryPolicy("flaky", rp)
+	client.SetRetryPolicy("unsafe", rp)
+	client.SetIdempotent("flaky")
+	client.Run()
+	defer client.Stop()
+
+	if err := server.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := client.Dial("server", network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if p, err := client.CallWait("server", "flaky", &jsonReq{Id: 1}, 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if p.(*jsonResp).Id != 1 {
+		t.Log(p)
+		t.FailNow()
+	}
+
+	// not idempotent
+	if _, err := client.CallWait("server", "unsafe", &jsonReq{Id: 2}, 5); StatusOf(err) != STATUS_UNAVAILABLE {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if _, err := client.CallWait("none", "flaky", &jsonReq{Id: 3}, 5); err != ErrOutErrorEndPointNotExist {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// 2 retries for each flaky call
+	time.Sleep(100 * time.Millisecond)
+	if client.stats.rpcRetry != 4 {
+		t.Log(&client.stats)
+		t.FailNow()
+	}
+}
+
+func TestRouterMetadata(t *testing.T) {
+	pf := NewJSONFactory()
+	hf := NewRPCHeaderFactory(pf)
+
+	network := "tcp"
+	address := "localhost:10013"
+
+	r, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	r.HandleRPC("md", func(ctx *RPCContext, p Payload) (Payload, error) {
+		if ctx.Metadata["key"] != "value" {
+			return nil, NewStatusError(STATUS_INVALID_ARGUMENT, "no metadata")
+		}
+		return ctx.Metadata, nil
+	})
+	r.Run()
+	defer r.Stop()
+
+	if err := r.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := r.Dial("server", network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	md := Metadata{"key": "value"}
+	if p, err := r.CallWait("server", "md", WithMetadata(md, nil), 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if p.(map[string]interface{})["key"] != "value" {
+		t.Log(p)
+		t.FailNow()
+	}
+
+	if _, err := r.CallWait("server", "md", nil, 5); err == nil {
+		t.FailNow()
+	} else if se, ok := err.(*StatusError); !ok || se.Code() != STATUS_INVALID_ARGUMENT || se.Message() != "no metadata" {
+		t.Log(err)
+		t.FailNow()
+	}
+}
@@ -59,6 +59,16 @@ func (rm *ResourceManager) Get() Resource {
 	}
 }
 
+// TryGet returns nil instead of waiting if no resource is available.
+func (rm *ResourceManager) TryGet() Resource {
+	select {
+	case r := <-rm.ch:
+		return r
+	default:
+		return nil
+	}
+}
+
 func (rm *ResourceManager) Put(r Resource) {
 	select {
 	case rm.ch <- r:
@@ -44,7 +44,7 @@ type RouteRPCPayload interface {
 	RPCInfo
 
 	// Run inside router goroutine
-	Serve(*Router, string, string, uint64, Payload)
+	Serve(*Router, *RPCContext, uint64, Payload)
 	Return(*Router, RouteRPCPayload)
 }
 
@@ -80,6 +80,11 @@ type EndPoint struct {
 	in  chan Payload
 	out chan Payload
 
+	ins  [PRIORITY_LEVELS]chan Payload // set by Router
+	outs [PRIORITY_LEVELS]chan Payload
+
+	peer *Peer
+
 	logger *log.Logger
 }
 
@@ -92,6 +97,11 @@ func NewEndPoint(name string, c net.Conn, out chan Payload, in chan Payload, mf
 	ep.in = in
 	ep.out = out
 
+	ep.outs[PRIORITY_NORMAL] = out
+	for i := PRIORITY_NORMAL + 1; i < PRIORITY_LEVELS; i++ {
+		ep.outs[i] = make(chan Payload, cap(out)/4)
+	}
+
 	ep.pw = pw
 
 	ep.logger = logger
@@ -116,18 +126,20 @@ func (ep *EndPoint) Stop() {
 
 func (ep *EndPoint) Cleanup() {
 	// TODO: use Router.CleanupEndPoint
-cleanup:
-	for {
-		select {
-		case p := <-ep.out:
-			// TODO: generalize interface
-			p.(*routeMsg).Recycle()
-		default:
-			break cleanup
+	for _, out := range ep.outs {
+	cleanup:
+		for {
+			select {
+			case p := <-out:
+				// TODO: generalize interface
+				p.(*routeMsg).Recycle()
+			default:
+				break cleanup
+			}
 		}
-	}
 
-	close(ep.out)
+		close(out)
+	}
 }
 
 func (ep *EndPoint) In() chan Payload {
@@ -138,6 +150,17 @@ func (ep *EndPoint) Out() chan Payload {
 	return ep.out
 }
 
+func (ep *EndPoint) InQueue(p Payload) chan Payload {
+	if pi, ok := p.(PriorityInfo); ok && ep.ins[pi.GetPriority()] != nil {
+		return ep.ins[pi.GetPriority()]
+	}
+	return ep.in
+}
+
+func (ep *EndPoint) OutQueue(prio Priority) chan Payload {
+	return ep.outs[prio]
+}
+
 func (ep *EndPoint) Wrap(p Payload) Payload {
 	if ep.pw == nil {
 		return p
@@ -169,6 +192,25 @@ func (ep *EndPoint) OutError(err error) {
 	ep.pw.Error(ep, err)
 }
 
+// OutMsgError passes the message dropped by Writer to Router, it runs inside
+// Writer goroutine.
+func (ep *EndPoint) OutMsgError(mb MsgBuffer, err error) {
+	if ep.pw == nil {
+		return
+	}
+
+	m := ep.Wrap(nil).(*routeMsg)
+	mb.GetPayloadInfo(m)
+	m.out_err = err
+
+	// never wait, Router may wait for the Writer.
+	select {
+	case ep.InQueue(m) <- m:
+	default:
+		m.Recycle()
+	}
+}
+
 func (r *Router) Error(ep *EndPoint, err error) {
 	r.DelEndPoint(ep.name)
 }
@@ -179,13 +221,17 @@ func (r *Router) Wrap(p Payload) RoutePayload {
 
 	in.Reset()
 	in.p = p
+	if r.adm != nil {
+		in.recv = time.Now()
+	}
 
 	return in
 }
 
 func (r *Router) Unwrap(p RoutePayload) Payload {
 	if m, ok := p.(*routeMsg); ok {
-		if !m.IsRPC() || !m.IsRequest() {
+		// rpc request is kept in Router.calls until reply or timeout.
+		if !m.IsRPC() || !m.IsRequest() || m.IsStream() {
 			m.Recycle()
 		}
 		return m.p
@@ -211,12 +257,34 @@ type routeMsg struct {
 	rpc        string
 	is_rpc     bool
 	is_request bool
+	stream     bool
+	stream_end bool
+	topic_op   uint16
+	prio       Priority
+
+	status     Status
+	status_msg string
+	md         Metadata
 
 	p Payload
 
 	r  *Router   // owner
 	to time.Time // ttl
 
+	attempt  int       // retried times
+	retry_at time.Time // backoff
+
+	hedge *hedgeCall
+	sent  time.Time
+	probe bool // half-open circuit
+
+	out_err error // the message is dropped by Writer
+
+	limited bool // holds in-flight slots
+
+	recv  time.Time // received by Reader
+	start time.Time // admitted request is served since
+
 	cb  RPCCallback_func
 	arg RPCCallback_arg
 }
@@ -236,6 +304,20 @@ func (rm *routeMsg) Reset() *routeMsg {
 	rm.rpc = ""
 	rm.is_rpc = false
 	rm.is_request = false
+	rm.stream = false
+	rm.stream_end = false
+	rm.topic_op = 0
+	rm.prio = PRIORITY_NORMAL
+	rm.status = STATUS_OK
+	rm.status_msg = ""
+	rm.md = nil
+	rm.attempt = 0
+	rm.retry_at = time.Time{}
+	rm.hedge = nil
+	rm.probe = false
+	rm.out_err = nil
+	rm.limited = false
+	rm.start = time.Time{}
 	rm.p = nil
 	rm.cb = nil
 	rm.arg = nil
@@ -279,6 +361,47 @@ func (rm *routeMsg) SetIsReply() {
 	rm.is_request = false
 }
 
+func (rm *routeMsg) IsStream() bool {
+	return rm.stream
+}
+
+func (rm *routeMsg) SetIsStream() {
+	rm.stream = true
+}
+
+func (rm *routeMsg) IsStreamEnd() bool {
+	return rm.stream_end
+}
+
+func (rm *routeMsg) SetIsStreamEnd() {
+	rm.stream_end = true
+}
+
+func (rm *routeMsg) GetTopicOP() uint16 {
+	return rm.topic_op
+}
+
+func (rm *routeMsg) SetTopicOP(op uint16) {
+	rm.topic_op = op
+}
+
+func (rm *routeMsg) GetStatus() (Status, string) {
+	return rm.status, rm.status_msg
+}
+
+func (rm *routeMsg) SetStatus(status Status, msg string) {
+	rm.status = status
+	rm.status_msg = msg
+}
+
+func (rm *routeMsg) GetMetadata() Metadata {
+	return rm.md
+}
+
+func (rm *routeMsg) SetMetadata(md Metadata) {
+	rm.md = md
+}
+
 func (rm *routeMsg) GetRPCID() uint64 {
 	return rm.id
 }
@@ -309,11 +432,22 @@ func (rm *routeMsg) Error(err error) {
 }
 
 func (rm *routeMsg) When() time.Time {
+	if !rm.retry_at.IsZero() {
+		return rm.retry_at
+	}
 	return rm.to
 }
 
 func (rm *routeMsg) Timeout(now time.Time) {
+	if !rm.retry_at.IsZero() {
+		// backoff is done, send it again.
+		rm.r.resend(rm)
+		return
+	}
+
 	rm.r.stats.rpcTimeout++
+	rm.r.breakerDone(rm, ErrCallTimeout)
+	rm.r.limitRelease(rm)
 	go rm.cb(nil, rm.arg, ErrCallTimeout)
 	r := rm.r
 	if out, exist := r.calls[rm.GetRPCID()]; exist {
@@ -332,6 +466,16 @@ const (
 	RouterOPDelListener
 	RouterOPStopAddListener
 	RouterOPStopListener
+	RouterOPAddStream
+	RouterOPSubscribe
+	RouterOPUnsubscribe
+	RouterOPCallMany
+	RouterOPCancelCalls
+	RouterOPCancelHedge
+	RouterOPCancelCall
+	RouterOPCancelStream
+	RouterOPBreakerState
+	RouterOPTopicSupported
 )
 
 type Chan struct {
@@ -400,6 +544,20 @@ type routerStats struct {
 	rpcIn      uint64
 	rpcOut     uint64
 	rpcTimeout uint64
+	rpcRetry   uint64
+	rpcHedge   uint64
+
+	breakerOpen uint64
+	breakerFail uint64
+
+	limitQueue  uint64
+	limitReject uint64
+
+	rpcShed uint64
+
+	pubIn   uint64
+	pubOut  uint64
+	pubDrop uint64
 }
 
 func (rs *routerStats) String() string {
@@ -410,6 +568,16 @@ func (rs *routerStats) String() string {
 		fmt.Sprintf("Message Send: %v ", rs.msgOut) +
 		fmt.Sprintf("(RPC Send: %v) ", rs.rpcOut) +
 		fmt.Sprintf("RPC Timeout: %v ", rs.rpcTimeout) +
+		fmt.Sprintf("RPC Retry: %v ", rs.rpcRetry) +
+		fmt.Sprintf("RPC Hedge: %v ", rs.rpcHedge) +
+		fmt.Sprintf("Circuit Open: %v ", rs.breakerOpen) +
+		fmt.Sprintf("Circuit Fast-Fail: %v ", rs.breakerFail) +
+		fmt.Sprintf("Limit Queue: %v ", rs.limitQueue) +
+		fmt.Sprintf("Limit Reject: %v ", rs.limitReject) +
+		fmt.Sprintf("RPC Shed: %v ", rs.rpcShed) +
+		fmt.Sprintf("Publish Recv: %v ", rs.pubIn) +
+		fmt.Sprintf("Publish Send: %v ", rs.pubOut) +
+		fmt.Sprintf("Publish Drop: %v ", rs.pubDrop) +
 		fmt.Sprintf("Error: %v\n", rs.msgError)
 }
 
@@ -432,6 +600,15 @@ type Router struct {
 	out chan Payload
 	in  chan Payload
 
+	// queues of each priority, outs[PRIORITY_NORMAL] is out.
+	outs       [PRIORITY_LEVELS]chan Payload
+	ins        [PRIORITY_LEVELS]chan Payload
+	priorities map[string]Priority
+	weights    []int // priority scheduling, nil is strict
+
+	auth  Authenticator
+	authz map[string]AuthorizeRPC
+
 	clientOutMsgs *ResourceManager
 	serverOutMsgs *ResourceManager
 	inMsgs        *ResourceManager
@@ -441,12 +618,45 @@ type Router struct {
 	next  uint64
 	calls map[uint64]RouteRPCPayload
 
+	streams      map[uint64]*Stream    // client streams
+	sstreams     map[streamKey]*Stream // server streams
+	streamServes map[string]ServeStream
+
+	topicEPs  map[string]map[string]bool        // remote subscribers
+	topicSubs map[string]map[*Subscription]bool // local subscribers
+	pubMsgs   *ResourceManager
+	publimit  int
+
+	rpcServes  map[string]ServeRPC
+	retries    map[string]*RetryPolicy
+	idempotent map[string]bool
+
+	groups    map[string][]string
+	groupNext uint64
+	hedges    map[string]*hedgeState
+
+	bp       *BreakerPolicy
+	breakers map[string]*breaker
+
+	epLimitPolicies  map[string]*LimitPolicy
+	rpcLimitPolicies map[string]*LimitPolicy
+	epLimiters       map[string]*limiter
+	rpcLimiters      map[string]*limiter
+
+	adm *admission
+
 	tt *TimeoutTracker
 
 	serve ServePayload
 
 	timeout time.Duration
 
+	// CONTINUATION frame chunk size of new EndPoints
+	fragsize int
+	maxmsg   int
+	// max request body of new HTTP/2 listeners
+	h2maxbody int
+
 	stats routerStats
 
 	logger *log.Logger
@@ -474,18 +684,46 @@ func NewRouter(logger *log.Logger, serve ServePayload) (*Router, error) {
 	n := 16 * 128
 	r.in = make(chan Payload, n)
 	r.out = make(chan Payload, n*2)
+	r.ins[PRIORITY_NORMAL] = r.in
+	r.outs[PRIORITY_NORMAL] = r.out
+	for i := PRIORITY_NORMAL + 1; i < PRIORITY_LEVELS; i++ {
+		r.ins[i] = make(chan Payload, n)
+		r.outs[i] = make(chan Payload, n*2)
+	}
+	r.priorities = make(map[string]Priority)
+	r.authz = make(map[string]AuthorizeRPC)
 
 	r.waiters = NewResourceManager(n, func() Resource { w := new(waiter); w.ch = make(chan Payload, 1); w.r = r; return w })
 	r.calls = make(map[uint64]RouteRPCPayload)
+	r.streams = make(map[uint64]*Stream)
+	r.sstreams = make(map[streamKey]*Stream)
+	r.streamServes = make(map[string]ServeStream)
+	r.topicEPs = make(map[string]map[string]bool)
+	r.topicSubs = make(map[string]map[*Subscription]bool)
+	r.publimit = 1024
+	r.rpcServes = make(map[string]ServeRPC)
+	r.retries = make(map[string]*RetryPolicy)
+	r.idempotent = make(map[string]bool)
+	r.groups = make(map[string][]string)
+	r.hedges = make(map[string]*hedgeState)
+	r.breakers = make(map[string]*breaker)
+	r.epLimitPolicies = make(map[string]*LimitPolicy)
+	r.rpcLimitPolicies = make(map[string]*LimitPolicy)
+	r.epLimiters = make(map[string]*limiter)
+	r.rpcLimiters = make(map[string]*limiter)
 	r.next = 1
 	r.tt, _ = NewTimeoutTracker(100, n)
 
 	r.clientOutMsgs = NewResourceManager(n, func() Resource { return new(routeMsg) })
 	r.serverOutMsgs = NewResourceManager(n, func() Resource { return new(routeMsg) })
 	r.inMsgs = NewResourceManager(n, func() Resource { return new(routeMsg) })
+	r.pubMsgs = NewResourceManager(n, func() Resource { return new(routeMsg) })
 
 	r.serve = serve
 
+	r.fragsize = 64 * 1024
+	r.h2maxbody = defaultH2MaxBodySize
+
 	if logger == nil {
 		r.logger = log.New(os.Stderr, "", log.LstdFlags)
 	} else {
@@ -515,6 +753,16 @@ func (r *Router) requestOP(t int, obj ...interface{}) (interface{}, error) {
 			v_obj = t
 		case *EndPoint:
 			v_obj = t
+		case *Stream:
+			v_obj = t
+		case *Subscription:
+			v_obj = t
+		case *multiCall:
+			v_obj = t
+		case *hedgeCall:
+			v_obj = t
+		case *ctxCall:
+			v_obj = t
 		case string:
 			v_n = t
 		default:
@@ -588,12 +836,13 @@ stopEndPoint:
 		}
 	}
 
+	// C:Cancel in progress RPC.
+	r.requestOP(RouterOPCancelCalls)
+
 	// S/C:Stop Operations: No new EndPoint can be added.
 	r.opchs.Close()
 	r.ops.Close()
 
-	// C:TODO: in progress RPC?
-
 	r.tt.Stop()
 
 	// Reclaim resources
@@ -601,19 +850,29 @@ stopEndPoint:
 	r.clientOutMsgs.Close()
 	r.inMsgs.Close()
 	r.serverOutMsgs.Close()
+	r.pubMsgs.Close()
 
 	// Stop Loop
 	r.bg.Stop()
 
 	// Close channels
 	close(r.op)
-	close(r.in)
-	close(r.out)
+	for i := range r.ins {
+		close(r.ins[i])
+		close(r.outs[i])
+	}
 
 	// r.logger.Printf("%v\n", &r.stats)
 }
 
 func (r *Router) call(ep string, rpc string, p Payload, cb RPCCallback_func, arg RPCCallback_arg, to time.Time) {
+	md, p := unwrapMetadata(p)
+	md, err := r.credentials(ep, rpc, p, md)
+	if err != nil {
+		cb(nil, arg, err)
+		return
+	}
+
 	var out *routeMsg
 	if v := r.clientOutMsgs.Get(); v == nil {
 		cb(nil, arg, ErrOPRouterStopped)
@@ -622,8 +881,11 @@ func (r *Router) call(ep string, rpc string, p Payload, cb RPCCallback_func, arg
 		out = v.(*routeMsg)
 	}
 
+	out.Reset()
 	out.ep_name = ep
 	out.rpc = rpc
+	out.md = md
+	out.prio = r.priority(rpc, out.md)
 
 	// Locate service name
 	if rpc != "" {
@@ -640,7 +902,7 @@ func (r *Router) call(ep string, rpc string, p Payload, cb RPCCallback_func, arg
 	out.r = r
 
 	select {
-	case r.out <- out:
+	case r.outQueue(out) <- out:
 	default:
 		panic("routeMsg leaks?!")
 	}
@@ -651,7 +913,28 @@ func (r *Router) Write(ep string, p Payload) {
 }
 
 func (r *Router) newRouterEndPoint(name string, c net.Conn, mf MsgFactory) *EndPoint {
-	return NewEndPoint(name, c, make(chan Payload, 16*128), r.in, mf, r, r.logger)
+	ep := NewEndPoint(name, c, make(chan Payload, 16*128), r.in, mf, r, r.logger)
+	ep.ins = r.ins
+	ep.w.SetFragmentSize(r.fragsize)
+	ep.w.SetMaxMessageSize(r.maxmsg)
+	ep.r.SetMaxMessageSize(r.maxmsg)
+	ep.w.SetPriorityWeights(r.weights)
+	return ep
+}
+
+// SetFragmentSize sets the chunk size of messages sent by new EndPoints, the
+// message larger than n is fragmented into CONTINUATION frames. 0 disables
+// the fragmentation. It should be called before Dial()/ListenAndServe().
+func (r *Router) SetFragmentSize(n int) {
+	r.fragsize = n
+}
+
+// SetMaxMessageSize sets the max size of messages of new EndPoints, the
+// larger message is not sent and its sender fails with ErrMessageTooLarge,
+// the peer sending it is closed. 0 means the default(256MB). It should be
+// called before Dial()/ListenAndServe().
+func (r *Router) SetMaxMessageSize(n int) {
+	r.maxmsg = n
 }
 
 func (r *Router) newHijackedEndPoint(name string, c net.Conn, mf MsgFactory, logger *log.Logger) *EndPoint {
@@ -707,6 +990,9 @@ func (r *Router) addEndPoint(ep *EndPoint) error {
 func (r *Router) delEndPoint(name string) (*EndPoint, error) {
 	if ep, exist := r.nmap[name]; exist {
 		delete(r.nmap, name)
+		r.unsubscribeEndPoint(name)
+		r.failEndPointCalls(name)
+		delete(r.breakers, name)
 		return ep, nil
 	}
 	return nil, ErrOPEndPointNotExist
@@ -769,7 +1055,14 @@ func (r *Router) delListener(name string) (*Listener, error) {
 func (r *Router) Dial(name string, network string, address string, mf MsgFactory) error {
 	if c, err := net.Dial(network, address); err != nil {
 		return err
-	} else if ep := r.newRouterEndPoint(name, c, mf); ep == nil {
+	} else {
+		return r.addConn(name, c, mf)
+	}
+}
+
+// addConn adds the EndPoint of dialed connection c.
+func (r *Router) addConn(name string, c net.Conn, mf MsgFactory) error {
+	if ep, err := r.newConnEndPoint(name, c, mf); ep == nil {
 		c.Close()
 		return err
 	} else if err := r.AddEndPoint(ep); err != nil {
@@ -849,6 +1142,31 @@ func (r *Router) LoopProcessOperation(op *opReq) {
 			r.stats.epOut++
 			break
 		}
+
+	case RouterOPAddStream:
+		ret = r.addStream(op.v.(*Stream))
+
+	case RouterOPSubscribe:
+		r.subscribe(op.v.(*Subscription))
+	case RouterOPUnsubscribe:
+		r.unsubscribe(op.v.(*Subscription))
+
+	case RouterOPCallMany:
+		r.callMany(op.v.(*multiCall))
+	case RouterOPCancelCalls:
+		r.cancelCalls(ErrOPRouterStopped)
+	case RouterOPCancelHedge:
+		r.cancelHedge(op.v.(*hedgeCall))
+	case RouterOPCancelCall:
+		r.cancelCall(op.v.(*ctxCall))
+	case RouterOPCancelStream:
+		r.cancelStream(op.v.(*Stream))
+	case RouterOPBreakerState:
+		ret = r.breakerState(op.n)
+	case RouterOPTopicSupported:
+		if ep, exist := r.nmap[op.n]; exist && !ep.supports(FRAME_SUBSCRIBE) {
+			ret = ErrFrameUnsupported
+		}
 	}
 
 	ch := op.ret
@@ -861,26 +1179,185 @@ func (r *Router) ProcessOut(out RoutePayload) {
 
 	r.stats.msgOut++
 
-	if out.IsRPC() {
-		r.RpcOut(out.(RouteRPCPayload))
+	if m := out.(*routeMsg); m.topic_op == FRAME_PUBLISH && m.ep_name == "" {
+		r.PublishOut(m)
+		m.Recycle()
+		return
+	} else if m.IsStream() {
+		if !r.StreamOut(m) {
+			// stream is finished
+			m.Recycle()
+			return
+		}
+	} else if out.IsRPC() && m.IsRequest() {
+		if group, exist := r.groups[m.ep_name]; exist {
+			r.groupOut(m, group)
+		}
+		if !r.limitAcquire(m) {
+			// queued or rejected
+			return
+		}
+		if !r.rpcRequestOut(m) {
+			return
+		}
+	} else if out.IsRPC() && !m.start.IsZero() {
+		// reply of admitted request
+		r.admitDone(time.Since(m.start))
+	}
+
+	r.writeOut(out)
+}
+
+// rpcRequestOut tracks the rpc request m, it returns false if m fails fast.
+func (r *Router) rpcRequestOut(m *routeMsg) bool {
+	c, ctxcall := m.arg.(*ctxCall)
+	if ctxcall && c.canceled {
+		r.limitRelease(m)
+		m.Recycle()
+		return false
+	}
+
+	if !r.breakerAllow(m) {
+		r.stats.breakerFail++
+		if !r.outError(m, ErrCircuitOpen) {
+			m.Recycle()
+		}
+		return false
+	}
+
+	if !r.RpcOut(m) {
+		m.Recycle()
+		return false
+	}
+	if !m.to.IsZero() {
+		// the server gets the deadline of caller(RPCContext.Deadline).
+		m.md = m.md.withTimeout(m.to)
+	}
+	if m.hedge != nil {
+		r.hedgeOut(m)
 	}
+	if ctxcall {
+		c.id = m.id
+	}
+	return true
+}
 
+func (r *Router) writeOut(out RoutePayload) {
 	// TODO: apply route rule
 
 	if ep, exist := r.nmap[out.GetEPName()]; exist {
 		//r.logger.Printf("router: %v rpcout: %T:%v", r, c.p, c.p)
 		if err := ep.write(out); err != nil {
 			r.stats.msgError++
-			go out.Error(err)
-			// TODO: redesign the api
-			out.(*routeMsg).Recycle()
+			r.breakerOut(out, err)
+			if !r.outError(out, err) {
+				// TODO: redesign the api
+				out.(*routeMsg).Recycle()
+			}
 		}
 	} else {
 		// race condition: Dial() is later than Call()
 		r.stats.msgError++
-		go out.Error(ErrOutErrorEndPointNotExist)
-		// TODO: redesign the api
-		out.(*routeMsg).Recycle()
+		r.breakerOut(out, ErrOutErrorEndPointNotExist)
+		if !r.outError(out, ErrOutErrorEndPointNotExist) {
+			// TODO: redesign the api
+			out.(*routeMsg).Recycle()
+		}
+	}
+}
+
+// outError notifies the sender, out should be recycled by the caller unless
+// it is kept to retry.
+func (r *Router) outError(out RoutePayload, err error) bool {
+	m := out.(*routeMsg)
+	if m.IsStream() {
+		r.StreamOutError(m, err)
+		return false
+	}
+
+	if m.IsRPC() && m.IsRequest() {
+		// added by RpcOut
+		if _, exist := r.calls[m.id]; exist {
+			delete(r.calls, m.id)
+			r.tt.Del(m.tid)
+		}
+
+		r.limitRelease(m)
+
+		if r.retry(m, err) {
+			return true
+		}
+	}
+
+	if m.cb != nil {
+		cb, arg := m.cb, m.arg
+		go cb(nil, arg, err)
+	}
+	return false
+}
+
+// outMsgError fails the sender of m which is dropped by Writer(see
+// EndPoint.OutMsgError).
+func (r *Router) outMsgError(m *routeMsg) {
+	r.stats.msgError++
+	err := m.out_err
+
+	switch {
+	case m.topic_op != 0 || !m.IsRPC():
+		// no one waits
+	case m.IsStream():
+		if s := r.lookupStream(m, m.IsRequest()); s != nil {
+			r.finishStream(s, err)
+			r.resetStream(s.ep, s.rpc, s.id, s.client, err)
+		}
+	case m.IsRequest():
+		if out, exist := r.calls[m.id]; exist {
+			r.breakerCancel(out.(*routeMsg))
+			if !r.outError(out, err) {
+				out.(*routeMsg).Recycle()
+			}
+		}
+	default:
+		// the client gets the error instead of the reply
+		var out *routeMsg
+		if v := r.serverOutMsgs.TryGet(); v == nil {
+			return
+		} else {
+			out = v.(*routeMsg)
+		}
+
+		out.Reset()
+		out.ep_name = m.ep_name
+		out.rpc = m.rpc
+		out.id = m.id
+		out.is_rpc = true
+		out.setStatusError(err)
+		out.r = r
+
+		r.stats.msgOut++
+		r.writeOut(out)
+	}
+}
+
+// cancelCalls fails all in progress rpcs and streams.
+func (r *Router) cancelCalls(err error) {
+	for id, out := range r.calls {
+		delete(r.calls, id)
+		r.tt.Del(out.GetTrackID())
+		m := out.(*routeMsg)
+		m.limited = false
+		cb, arg := m.cb, m.arg
+		go cb(nil, arg, err)
+		m.Recycle()
+	}
+
+	r.limitCancel(err)
+
+	for _, s := range r.streams {
+		r.finishStream(s, err)
+	}
+	for _, s := range r.sstreams {
+		r.finishStream(s, err)
 	}
 }
 
@@ -892,15 +1369,41 @@ func (r *Router) ProcessIn(in RoutePayload) {
 
 	// TODO: apply route rule
 
-	if in.IsRPC() {
+	if rm.out_err != nil {
+		r.outMsgError(rm)
+	} else if rm.topic_op != 0 {
+		r.TopicIn(rm)
+	} else if rm.IsStream() {
+		r.StreamIn(rm)
+	} else if in.IsRPC() {
 		if in.(RouteRPCPayload).IsRequest() {
 			// rpc request
 			// TODO: task queue
 			// TODO: server api
-			go in.(RouteRPCPayload).Serve(r, rm.ep_name, rm.rpc, rm.id, rm.p)
+			ctx := &RPCContext{Router: r, EP: rm.ep_name, RPC: rm.rpc, Metadata: rm.md, Priority: rm.prio}
+			ctx.Deadline = rm.md.deadline(time.Now(), time.Time{})
+			if ep, exist := r.nmap[rm.ep_name]; exist {
+				ctx.Peer = ep.peer
+			}
+			if r.adm == nil {
+				go in.(RouteRPCPayload).Serve(r, ctx, rm.id, rm.p)
+			} else if r.admit(rm) {
+				ctx.start = time.Now()
+				go in.(RouteRPCPayload).Serve(r, ctx, rm.id, rm.p)
+			} else {
+				r.shed(rm)
+			}
 		} else if out := r.RpcIn(in.(RouteRPCPayload)); out != nil {
 			// rpc reply
-			out.Return(r, in.(RouteRPCPayload))
+			if out.(*routeMsg).hedge != nil {
+				r.hedgeIn(out.(*routeMsg))
+			}
+			r.breakerDone(out.(*routeMsg), rm.statusError())
+			if err := rm.statusError(); err != nil && r.retry(out.(*routeMsg), err) {
+				// retrying
+			} else {
+				out.Return(r, in.(RouteRPCPayload))
+			}
 		} else {
 			// TODO: rpc timeout/cancel
 		}
@@ -913,6 +1416,9 @@ func (r *Router) ProcessIn(in RoutePayload) {
 }
 
 func (r *Router) Loop(quit chan struct{}) {
+	// the scheduling of in and out queues is independent.
+	ins, outs := newPrioScheduler(r.weights), newPrioScheduler(r.weights)
+
 forever:
 	for {
 		select {
@@ -922,17 +1428,57 @@ forever:
 			r.LoopProcessOperation(op)
 		case now := <-r.tt.Tick():
 			r.tt.TimeoutCheck(now)
+			r.limitTick(now)
+		default:
+		}
+
+		// Prioritized messages which are already queued.
+		busy := false
+		if p, ok := outs.next(&r.outs); ok {
+			r.ProcessOut(p.(RoutePayload))
+			busy = true
+		}
+		if p, ok := ins.next(&r.ins); ok {
+			r.ProcessIn(p.(RoutePayload))
+			busy = true
+		}
+		if busy {
+			continue
+		}
+
+		select {
+		case <-quit:
+			break forever
+		case op := <-r.op:
+			r.LoopProcessOperation(op)
+		case now := <-r.tt.Tick():
+			r.tt.TimeoutCheck(now)
+			r.limitTick(now)
+		case p := <-r.outs[PRIORITY_HIGHEST]:
+			r.ProcessOut(p.(RoutePayload))
+		case p := <-r.outs[PRIORITY_HIGHER]:
+			r.ProcessOut(p.(RoutePayload))
+		case p := <-r.outs[PRIORITY_HIGH]:
+			r.ProcessOut(p.(RoutePayload))
 		case p := <-r.out:
 			r.ProcessOut(p.(RoutePayload))
+		case p := <-r.ins[PRIORITY_HIGHEST]:
+			r.ProcessIn(p.(RoutePayload))
+		case p := <-r.ins[PRIORITY_HIGHER]:
+			r.ProcessIn(p.(RoutePayload))
+		case p := <-r.ins[PRIORITY_HIGH]:
+			r.ProcessIn(p.(RoutePayload))
 		case p := <-r.in:
 			r.ProcessIn(p.(RoutePayload))
 		}
 	}
 }
 
-func (r *Router) RpcOut(out RouteRPCPayload) {
+// RpcOut tracks the request out, it returns false if out is already timed
+// out(the callback is called).
+func (r *Router) RpcOut(out RouteRPCPayload) bool {
 	if !out.IsRequest() {
-		return
+		return true
 	}
 
 	r.stats.rpcOut++
@@ -940,11 +1486,15 @@ func (r *Router) RpcOut(out RouteRPCPayload) {
 	r.next++
 	if _, exist := r.calls[out.GetRPCID()]; exist {
 		panic("RpcOut id duplicate")
-	} else {
-		r.calls[out.GetRPCID()] = out
-		id, _ := r.tt.Add(out)
-		out.SetTrackID(id)
 	}
+
+	id, err := r.tt.Add(out)
+	if err == ErrTimeout {
+		return false
+	}
+	r.calls[out.GetRPCID()] = out
+	out.SetTrackID(id)
+	return true
 }
 
 func (r *Router) RpcIn(in RouteRPCPayload) RouteRPCPayload {
@@ -960,6 +1510,7 @@ func (r *Router) RpcIn(in RouteRPCPayload) RouteRPCPayload {
 	} else {
 		delete(r.calls, id)
 		r.tt.Del(out.GetTrackID())
+		r.limitRelease(out.(*routeMsg))
 		return out
 	}
 }
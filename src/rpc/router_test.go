@@ -4,11 +4,13 @@
 package rpc
 
 import (
+	"context"
 	"github.com/golang/protobuf/proto"
 	"math/rand"
 	"net"
 	pbt "rpc/pb_test"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -27,14 +29,6 @@ func ServiceProcessPayload(r *Router, name string, p Payload) Payload {
 		resp := pbt.NewResourceResp()
 		resp.Id = proto.Uint64(req.GetId())
 		return resp
-	} else if b, ok := p.([]byte); ok {
-		req := pbt.NewResourceReq()
-		if proto.Unmarshal(b, req) != nil {
-			panic("proto.Unmarshal error")
-		}
-		resp := pbt.NewResourceResp()
-		resp.Id = proto.Uint64(req.GetId())
-		return resp
 	} else {
 		panic("ServiceProcessPayload receieve wrong info")
 	}
@@ -58,7 +52,9 @@ func TestRouterSingle(t *testing.T) {
 		t.FailNow()
 	}
 
-	hf := NewRPCHeaderFactory(NewProtobufFactory())
+	pf := NewProtobufFactory()
+	pf.Register("rpc", func() Payload { return pbt.NewResourceReq() }, func() Payload { return pbt.NewResourceResp() })
+	hf := NewRPCHeaderFactory(pf)
 
 	name := "scheduler"
 	network := "tcp"
@@ -78,6 +74,14 @@ func TestRouterSingle(t *testing.T) {
 	req := pbt.NewResourceReq()
 	req.Id = proto.Uint64(1)
 
+	if p, err := r.CallWait(name, "rpc", req, 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if resp, ok := p.(*pbt.ResourceResp); !ok || resp.GetId() != 1 {
+		t.Log(p)
+		t.FailNow()
+	}
+
 	done := make(chan bool)
 	r.Call("scheduler", "rpc", req, ClientProcessReponse, done, 0)
 	<-done
@@ -126,6 +130,55 @@ func TestRouterMultiple(t *testing.T) {
 	r.Stop()
 }
 
+func ServiceProcessEmptyPayload(r *Router, name string, p Payload) Payload {
+	if p == nil {
+		return nil
+	}
+	return ServiceProcessPayload(r, name, p)
+}
+
+func TestRouterEmptyPayload(t *testing.T) {
+	r, err := NewRouter(nil, ServiceProcessEmptyPayload)
+	if err != nil {
+		t.FailNow()
+	}
+
+	hf := NewMsgHeaderFactory(pbt.NewMsgProtobufFactory())
+
+	name := "scheduler"
+	network := "tcp"
+	address := "localhost:10002"
+
+	r.Run()
+	defer r.Stop()
+
+	if err := r.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := r.Dial(name, network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// empty request and empty reply
+	if p, err := r.CallWait(name, "rpc", nil, 5); err != nil || p != nil {
+		t.Log(p, err)
+		t.FailNow()
+	}
+
+	// reader should not hang after an empty message
+	req := pbt.NewResourceReq()
+	req.Id = proto.Uint64(1)
+	if p, err := r.CallWait(name, "rpc", req, 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if resp, ok := p.(*pbt.ResourceResp); !ok || resp.GetId() != 1 {
+		t.Log(p)
+		t.FailNow()
+	}
+}
+
 /*
 func TestReadWriter(t *testing.T) {
 	s, c := net.Pipe()
@@ -177,6 +230,93 @@ func BenchmarkPipeReadWriter(b *testing.B) {
 
 }
 
+func BenchmarkPipeReadWriterMsgpack(b *testing.B) {
+	s, c := net.Pipe()
+
+	ch_c_w := make(chanPayload, 1024)
+	ch_s_w := make(chanPayload, 1024)
+	ch_d := make(chanPayload, 1024)
+
+	pf := NewMsgMsgpackFactory()
+	pf.RegisterType(&msgpackResp{})
+	hf := NewMsgHeaderFactory(pf)
+
+	ep_c := NewEndPoint("c", c, ch_c_w, ch_d, hf, nil, nil)
+	ep_s := NewEndPoint("s", s, ch_s_w, ch_s_w, hf, nil, nil)
+
+	ep_c.Run()
+	ep_s.Run()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		req := &msgpackResp{Id: 1}
+		for pb.Next() {
+			ch_c_w <- req
+			<-ch_d
+		}
+	})
+
+}
+
+func BenchmarkCodecProtobuf(b *testing.B) {
+	pf := NewProtobufFactory()
+	pf.Register("rpc", func() Payload { return pbt.NewResourceReq() }, nil)
+	pb := pf.NewBuffer()
+
+	req := pbt.NewResourceReq()
+	req.Id = proto.Uint64(1 << 40)
+	buf := make([]byte, 128)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		nb, err := pb.Marshal(req, buf)
+		if err != nil {
+			b.FailNow()
+		}
+		if _, err := pb.Unmarshal("rpc", true, nb); err != nil {
+			b.FailNow()
+		}
+	}
+}
+
+func BenchmarkCodecMsgpack(b *testing.B) {
+	pf := NewMsgpackFactory()
+	pf.RegisterType("rpc", &msgpackResp{}, nil)
+	pb := pf.NewBuffer()
+
+	req := &msgpackResp{Id: 1 << 40}
+	buf := make([]byte, 128)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		nb, err := pb.Marshal(req, buf)
+		if err != nil {
+			b.FailNow()
+		}
+		if _, err := pb.Unmarshal("rpc", true, nb); err != nil {
+			b.FailNow()
+		}
+	}
+}
+
+func BenchmarkCodecMsgpackSchemaless(b *testing.B) {
+	pb := NewMsgpackFactory().NewBuffer()
+
+	req := map[string]interface{}{"id": uint64(1 << 40)}
+	buf := make([]byte, 128)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		nb, err := pb.Marshal(req, buf)
+		if err != nil {
+			b.FailNow()
+		}
+		if _, err := pb.Unmarshal("rpc", true, nb); err != nil {
+			b.FailNow()
+		}
+	}
+}
+
 func BenchmarkTCPReadWriter(b *testing.B) {
 	network := "tcp"
 	address := "localhost:10008"
@@ -628,3 +768,95 @@ func BenchmarkRawTCPIOPS(b *testing.B) {
 	c.Close()
 	l.Close()
 }
+
+func TestRouterCallContext(t *testing.T) {
+	r, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+
+	pf := NewJSONFactory()
+	pf.Register("rpc", func() Payload { return new(jsonReq) }, func() Payload { return new(jsonResp) })
+	hf := NewRPCHeaderFactory(pf)
+
+	r.HandleRPC("slow", func(ctx *RPCContext, p Payload) (Payload, error) {
+		time.Sleep(500 * time.Millisecond)
+		return p, nil
+	})
+	// the canceled call releases its slot
+	r.SetMethodLimit("slow", &LimitPolicy{MaxInFlight: 1})
+
+	name := "scheduler"
+	network := "tcp"
+	address := "localhost:10029"
+
+	r.Run()
+	defer r.Stop()
+
+	if err := r.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := r.Dial(name, network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// short deadlines, a call never fails before its deadline.
+	var late int32
+	call := func(id uint64) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		if p, err := r.CallContext(ctx, name, "rpc", &jsonReq{Id: id}); err != nil {
+			if time.Since(start) < 20*time.Millisecond {
+				return err
+			}
+			// the machine is busy
+			atomic.AddInt32(&late, 1)
+		} else if resp, ok := p.(*jsonResp); !ok || resp.Id != id {
+			return &Error{err: "reply mismatch"}
+		}
+		return nil
+	}
+	for i := 0; i < 200; i++ {
+		if err := call(uint64(i)); err != nil {
+			t.Log(i, err)
+			t.FailNow()
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 64)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			for j := 0; j < 10 && errs[i] == nil; j++ {
+				errs[i] = call(uint64(i*10 + j))
+			}
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Log(i, err)
+			t.FailNow()
+		}
+	}
+	if late > 84 {
+		t.Log("late:", late)
+		t.FailNow()
+	}
+
+	// canceled
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		start := time.Now()
+		if _, err := r.CallContext(ctx, name, "slow", nil); StatusOf(err) != STATUS_CANCELLED || time.Since(start) > 250*time.Millisecond {
+			t.Log(i, err, time.Since(start))
+			t.FailNow()
+		}
+	}
+}
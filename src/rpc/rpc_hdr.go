@@ -3,44 +3,118 @@
 
 package rpc
 
-import ()
+import (
+	"sync"
+)
+
+var (
+	ErrRPCPayloadType error = &Error{err: "rpc payload type mismatch"}
+	ErrRPCHeaderMeta  error = &Error{err: "rpc header metadata is corrupted"}
+)
 
 type RPCPayloadBuffer interface {
 	Marshal(Payload, []byte) ([]byte, error)
-	Unmarshal(string, []byte) (Payload, error)
+	// Unmarshal decodes the payload of rpc name, the bool tells whether it
+	// is a request or a reply.
+	Unmarshal(string, bool, []byte) (Payload, error)
 }
 
 type RPCPayloadFactory interface {
 	NewBuffer() RPCPayloadBuffer
+	// Register the request/reply type of rpc name.
+	Register(string, RPCNewFunc, RPCNewFunc)
+}
+
+// RPCNewFunc returns a new empty payload to decode into.
+type RPCNewFunc func() Payload
+
+// RPCTypeRegistry maps rpc name to request type(for servers) and reply
+// type(for pending calls), it is shared by all the buffers of a factory.
+type RPCTypeRegistry struct {
+	mu  sync.RWMutex
+	req map[string]RPCNewFunc
+	rep map[string]RPCNewFunc
+}
+
+func NewRPCTypeRegistry() *RPCTypeRegistry {
+	tr := new(RPCTypeRegistry)
+	tr.req = make(map[string]RPCNewFunc)
+	tr.rep = make(map[string]RPCNewFunc)
+	return tr
+}
+
+func (tr *RPCTypeRegistry) Register(name string, req RPCNewFunc, rep RPCNewFunc) {
+	tr.mu.Lock()
+	if req != nil {
+		tr.req[name] = req
+	}
+	if rep != nil {
+		tr.rep[name] = rep
+	}
+	tr.mu.Unlock()
+}
+
+// New returns nil if the type of rpc name is not registered.
+func (tr *RPCTypeRegistry) New(name string, request bool) Payload {
+	var f RPCNewFunc
+
+	tr.mu.RLock()
+	if request {
+		f = tr.req[name]
+	} else {
+		f = tr.rep[name]
+	}
+	tr.mu.RUnlock()
+
+	if f == nil {
+		return nil
+	}
+	return f()
 }
 
 const (
 	RPC_RPC = 1 << iota
 	RPC_REQUEST
+	// status and metadata follow rpc_name.
+	RPC_META
 )
 
 // RPCHeader
 type rpcHeader struct {
 	length         uint32
 	rpcid          uint64
-	version        uint16
+	codec          uint8
+	version        uint8
 	flags          uint16
 	rpc_name_len   uint16
 	payload_offset uint16
 	checksum       uint32
 
 	/* variable part */
-	rpc_name string
+	rpc_name   string
+	status     Status
+	status_msg string
+	md         Metadata
+	meta_len   uint16
 }
 
 type RPCHeaderFactory struct {
 	pf RPCPayloadFactory
+
+	codecs map[uint8]RPCPayloadFactory
 }
 
-func NewRPCHeaderFactory(pf RPCPayloadFactory) MsgFactory {
+func NewRPCHeaderFactory(pf RPCPayloadFactory) *RPCHeaderFactory {
 	hf := new(RPCHeaderFactory)
 	hf.pf = pf
-	return MsgFactory(hf)
+	hf.codecs = make(map[uint8]RPCPayloadFactory)
+	return hf
+}
+
+// RegisterCodec adds a codec which is chosen by WithCodec() on sender and
+// by the codec id of frame on receiver. It must be called before NewBuffer().
+func (hf *RPCHeaderFactory) RegisterCodec(id uint8, pf RPCPayloadFactory) {
+	hf.codecs[id] = pf
 }
 
 func (hf *RPCHeaderFactory) NewBuffer() MsgBuffer {
@@ -50,6 +124,10 @@ func (hf *RPCHeaderFactory) NewBuffer() MsgBuffer {
 	hb.hdrlen = 24
 
 	hb.b = hf.pf.NewBuffer()
+	hb.codecs = make(map[uint8]RPCPayloadBuffer)
+	for id, pf := range hf.codecs {
+		hb.codecs[id] = pf.NewBuffer()
+	}
 
 	return MsgBuffer(hb)
 }
@@ -58,6 +136,15 @@ type rpcHeaderBuffer struct {
 	h      rpcHeader
 	hdrlen uint32
 	b      RPCPayloadBuffer
+
+	codecs map[uint8]RPCPayloadBuffer
+}
+
+func (hb *rpcHeaderBuffer) codec(id uint8) RPCPayloadBuffer {
+	if id == CodecDefault {
+		return hb.b
+	}
+	return hb.codecs[id]
 }
 
 func (hb *rpcHeaderBuffer) GetHdrLen() uint32 {
@@ -66,28 +153,52 @@ func (hb *rpcHeaderBuffer) GetHdrLen() uint32 {
 
 /* The rpc_name stores at the beginning of payload. */
 func (hb *rpcHeaderBuffer) GetPayloadLen() uint32 {
-	return hb.h.length - (hb.hdrlen + uint32(hb.h.rpc_name_len))
+	return hb.h.length - hb.hdrlen
 }
 
 func (hb *rpcHeaderBuffer) MarshalPayload(p Payload, b []byte) ([]byte, error) {
-	if vb, err := hb.marshalHeaderVariable(b); err != nil {
-		return nil, err
-	} else if pb, err := hb.b.Marshal(p, b); err != nil {
+	vb, err := hb.marshalHeaderVariable(b[0:0])
+	if err != nil {
 		return nil, err
+	}
+
+	hb.h.codec, p = unwrapCodec(p)
+	c := hb.codec(hb.h.codec)
+	if c == nil {
+		return nil, ErrCodecUnknown
+	}
+
+	vlen := len(vb)
+	inplace := vlen <= len(b) && (vlen == 0 || &vb[0] == &b[0])
+
+	var pb []byte
+	if inplace {
+		pb, err = c.Marshal(p, b[vlen:])
 	} else {
-		return b[0 : len(vb)+len(pb)], nil
+		pb, err = c.Marshal(p, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pb) == 0 {
+		return vb, nil
+	} else if inplace && vlen < len(b) && &pb[0] == &b[vlen] {
+		// marshal in place
+		return b[0 : vlen+len(pb)], nil
 	}
+
+	// the payload buffer was reallocated, append it after the variable part.
+	return append(b[0:vlen], pb...), nil
 }
 
 func (hb *rpcHeaderBuffer) UnmarshalPayload(b []byte) (Payload, error) {
 	if pb, err := hb.unmarshalHeaderVariable(b); err != nil {
 		return nil, err
+	} else if c := hb.codec(hb.h.codec); c == nil {
+		return nil, ErrCodecUnknown
 	} else {
-		// copy this to upper level, performance hurt.
-		nb := make([]byte, len(pb))
-		copy(nb, pb)
-		return nb, nil
-		//return hb.b.Unmarshal(hb.h.rpc_name, b)
+		return c.Unmarshal(hb.h.rpc_name, (hb.h.flags&RPC_REQUEST) == RPC_REQUEST, pb)
 	}
 }
 
@@ -100,9 +211,20 @@ func (hb *rpcHeaderBuffer) SetPayloadInfo(p Payload) {
 		hb.h.flags |= RPC_RPC
 		i := p.(RPCInfo)
 		hb.h.rpcid = i.GetRPCID()
+		hb.h.rpc_name = i.GetRPCName()
+		hb.h.rpc_name_len = uint16(len(hb.h.rpc_name))
 		if i.IsRequest() {
 			hb.h.flags |= RPC_REQUEST
 		}
+		hb.h.flags |= marshalFrameFlags(p)
+
+		if mi, ok := p.(MetaInfo); ok {
+			hb.h.status, hb.h.status_msg = mi.GetStatus()
+			hb.h.md = mi.GetMetadata()
+			if hb.h.status != STATUS_OK || hb.h.status_msg != "" || len(hb.h.md) > 0 {
+				hb.h.flags |= RPC_META
+			}
+		}
 	}
 }
 
@@ -115,9 +237,16 @@ func (hb *rpcHeaderBuffer) GetPayloadInfo(p Payload) {
 		rp.SetIsRPC()
 		i := p.(RPCInfo)
 		i.SetRPCID(hb.h.rpcid)
+		i.SetRPCName(hb.h.rpc_name)
 		if (hb.h.flags & RPC_REQUEST) == RPC_REQUEST {
 			i.SetIsRequest()
 		}
+		unmarshalFrameFlags(hb.h.flags, p)
+
+		if mi, ok := p.(MetaInfo); ok && (hb.h.flags&RPC_META) != 0 {
+			mi.SetStatus(hb.h.status, hb.h.status_msg)
+			mi.SetMetadata(hb.h.md)
+		}
 	}
 }
 
@@ -126,12 +255,10 @@ func (hb *rpcHeaderBuffer) MarshalHeader(b []byte, p Payload, l uint32) error {
 		return nil
 	}
 
-	// TODO: Set rpc_name
-	hb.h.rpc_name_len = uint16(len(hb.h.rpc_name))
 	// Set payload_offset
-	hb.h.payload_offset = uint16(uint16(hb.hdrlen) + hb.h.rpc_name_len)
-	// Set length
-	hb.h.length = hb.hdrlen + uint32(hb.h.rpc_name_len) + l
+	hb.h.payload_offset = uint16(hb.hdrlen) + hb.h.rpc_name_len + hb.h.meta_len
+	// Set length, l includes the variable part.
+	hb.h.length = hb.hdrlen + l
 
 	off := 0
 	b[off] = byte(hb.h.length >> 24)
@@ -150,7 +277,7 @@ func (hb *rpcHeaderBuffer) MarshalHeader(b []byte, p Payload, l uint32) error {
 	b[off+7] = byte(hb.h.rpcid)
 	off += 8
 
-	b[off] = byte(hb.h.version >> 8)
+	b[off] = byte(hb.h.codec)
 	b[off+1] = byte(hb.h.version)
 	off += 2
 
@@ -175,11 +302,59 @@ func (hb *rpcHeaderBuffer) MarshalHeader(b []byte, p Payload, l uint32) error {
 	return nil
 }
 
+// marshalHeaderVariable appends the variable part to b.
 func (hb *rpcHeaderBuffer) marshalHeaderVariable(b []byte) ([]byte, error) {
 	// Write rpc_name
-	copy(b[0:], hb.h.rpc_name)
+	b = append(b, hb.h.rpc_name...)
+
+	if (hb.h.flags & RPC_META) == 0 {
+		return b, nil
+	}
 
-	return b[0:hb.h.rpc_name_len], nil
+	// Write status, status message and metadata
+	off := len(b)
+	b = append(b, byte(hb.h.status>>8), byte(hb.h.status))
+	b = appendString16(b, hb.h.status_msg)
+	n := 0
+	for k, v := range hb.h.md {
+		if len(k) <= 0xffff && len(v) <= 0xffff {
+			n++
+		}
+	}
+	b = append(b, byte(n>>8), byte(n))
+	for k, v := range hb.h.md {
+		if len(k) <= 0xffff && len(v) <= 0xffff {
+			b = appendString16(b, k)
+			b = appendString16(b, v)
+		}
+	}
+
+	if len(b)-off > 0xffff-int(hb.hdrlen)-int(hb.h.rpc_name_len) {
+		return nil, ErrRPCHeaderMeta
+	}
+	hb.h.meta_len = uint16(len(b) - off)
+
+	return b, nil
+}
+
+// appendString16 appends s with 16 bits length, s is truncated.
+func appendString16(b []byte, s string) []byte {
+	if len(s) > 0xffff {
+		s = s[:0xffff]
+	}
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+func readString16(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, ErrRPCHeaderMeta
+	}
+	l := int(b[0])<<8 | int(b[1])
+	if len(b) < 2+l {
+		return "", nil, ErrRPCHeaderMeta
+	}
+	return string(b[2 : 2+l]), b[2+l:], nil
 }
 
 func (hb *rpcHeaderBuffer) UnmarshalHeader(b []byte) error {
@@ -195,7 +370,8 @@ func (hb *rpcHeaderBuffer) UnmarshalHeader(b []byte) error {
 	hb.h.rpcid |= uint64(b[off])<<24 | uint64(b[off+1])<<16 | uint64(b[off+2])<<8 | uint64(b[off+3])
 	off += 4
 
-	hb.h.version = uint16(b[off])<<8 | uint16(b[off+1])
+	hb.h.codec = uint8(b[off])
+	hb.h.version = uint8(b[off+1])
 	off += 2
 	hb.h.flags = uint16(b[off])<<8 | uint16(b[off+1])
 	off += 2
@@ -210,18 +386,66 @@ func (hb *rpcHeaderBuffer) UnmarshalHeader(b []byte) error {
 }
 
 func (hb *rpcHeaderBuffer) unmarshalHeaderVariable(b []byte) ([]byte, error) {
+	off := int(hb.h.payload_offset) - int(hb.hdrlen)
+	if int(hb.h.rpc_name_len) > len(b) || off < int(hb.h.rpc_name_len) || off > len(b) {
+		return nil, ErrRPCHeaderMeta
+	}
+
 	// Read rpc_name
 	hb.h.rpc_name = string(b[0:hb.h.rpc_name_len])
-	return b[hb.h.rpc_name_len:], nil
+
+	if (hb.h.flags & RPC_META) == 0 {
+		return b[off:], nil
+	}
+
+	// Read status, status message and metadata
+	mb := b[hb.h.rpc_name_len:off]
+	if len(mb) < 2 {
+		return nil, ErrRPCHeaderMeta
+	}
+	hb.h.status = Status(uint16(mb[0])<<8 | uint16(mb[1]))
+
+	var err error
+	if hb.h.status_msg, mb, err = readString16(mb[2:]); err != nil {
+		return nil, err
+	}
+
+	if len(mb) < 2 {
+		return nil, ErrRPCHeaderMeta
+	}
+	n := int(mb[0])<<8 | int(mb[1])
+	mb = mb[2:]
+
+	hb.h.md = nil
+	if n > 0 {
+		hb.h.md = make(Metadata, n)
+	}
+	for i := 0; i < n; i++ {
+		var k, v string
+		if k, mb, err = readString16(mb); err != nil {
+			return nil, err
+		}
+		if v, mb, err = readString16(mb); err != nil {
+			return nil, err
+		}
+		hb.h.md[k] = v
+	}
+
+	return b[off:], nil
 }
 
 func (hb *rpcHeaderBuffer) Reset() {
 	hb.h.length = 0
 	hb.h.rpcid = 0
+	hb.h.codec = CodecDefault
 	hb.h.version = 1
 	hb.h.flags = 0
 	hb.h.rpc_name_len = 0
 	hb.h.payload_offset = 0
 	hb.h.checksum = 0
 	hb.h.rpc_name = ""
+	hb.h.status = STATUS_OK
+	hb.h.status_msg = ""
+	hb.h.md = nil
+	hb.h.meta_len = 0
 }
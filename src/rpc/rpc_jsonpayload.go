@@ -0,0 +1,77 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+type jsonFactory struct {
+	*RPCTypeRegistry
+}
+
+// NewJSONFactory returns a RPCPayloadFactory which encodes payload as JSON.
+// Unregistered rpc payload is decoded into generic JSON value(map, slice...).
+func NewJSONFactory() RPCPayloadFactory {
+	pf := new(jsonFactory)
+	pf.RPCTypeRegistry = NewRPCTypeRegistry()
+	return RPCPayloadFactory(pf)
+}
+
+type jsonBuffer struct {
+	types *RPCTypeRegistry
+}
+
+func (pf *jsonFactory) NewBuffer() RPCPayloadBuffer {
+	jb := new(jsonBuffer)
+	jb.types = pf.RPCTypeRegistry
+
+	return RPCPayloadBuffer(jb)
+}
+
+func (jb *jsonBuffer) Marshal(p Payload, b []byte) ([]byte, error) {
+	return jsonMarshal(p, b)
+}
+
+func (jb *jsonBuffer) Unmarshal(name string, request bool, b []byte) (Payload, error) {
+	if len(b) == 0 {
+		// zero length payload
+		return nil, nil
+	}
+
+	p := jb.types.New(name, request)
+	if p == nil {
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// jsonMarshal encodes p into b if b is large enough.
+func jsonMarshal(p interface{}, b []byte) ([]byte, error) {
+	if p == nil {
+		// zero length payload
+		return b[0:0], nil
+	}
+
+	buf := bytes.NewBuffer(b[0:0])
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(p); err != nil {
+		return nil, err
+	}
+
+	// Encode() always appends a newline.
+	nb := buf.Bytes()
+	return nb[0 : len(nb)-1], nil
+}
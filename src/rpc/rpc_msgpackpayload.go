@@ -0,0 +1,93 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"reflect"
+)
+
+type MsgpackFactory struct {
+	*RPCTypeRegistry
+}
+
+// NewMsgpackFactory returns a RPCPayloadFactory which encodes payload as
+// MessagePack. Unregistered rpc payload is decoded into generic value.
+func NewMsgpackFactory() *MsgpackFactory {
+	pf := new(MsgpackFactory)
+	pf.RPCTypeRegistry = NewRPCTypeRegistry()
+	return pf
+}
+
+// RegisterType registers the request/reply type of rpc name by sample
+// values, e.g. RegisterType("rpc", &Req{}, &Rep{}). nil means unregistered.
+func (pf *MsgpackFactory) RegisterType(name string, req interface{}, rep interface{}) {
+	pf.Register(name, msgpackNewFunc(req), msgpackNewFunc(rep))
+}
+
+func (pf *MsgpackFactory) NewBuffer() RPCPayloadBuffer {
+	mb := new(msgpackBuffer)
+	mb.types = pf.RPCTypeRegistry
+
+	return RPCPayloadBuffer(mb)
+}
+
+type msgpackBuffer struct {
+	types *RPCTypeRegistry
+}
+
+func (mb *msgpackBuffer) Marshal(p Payload, b []byte) ([]byte, error) {
+	if p == nil {
+		// zero length payload
+		return b[0:0], nil
+	}
+	return msgpackMarshal(b[0:0], p)
+}
+
+func (mb *msgpackBuffer) Unmarshal(name string, request bool, b []byte) (Payload, error) {
+	if len(b) == 0 {
+		// zero length payload
+		return nil, nil
+	}
+
+	p := mb.types.New(name, request)
+	if p == nil {
+		d := msgpackDecoder{b: b}
+		return d.value()
+	}
+
+	return msgpackDecodeInto(b, p)
+}
+
+// msgpackNewFunc creates the new function from a sample value by reflection.
+func msgpackNewFunc(sample interface{}) RPCNewFunc {
+	if sample == nil {
+		return nil
+	}
+
+	t := reflect.TypeOf(sample)
+	if t.Kind() == reflect.Ptr {
+		et := t.Elem()
+		return func() Payload { return reflect.New(et).Interface() }
+	}
+	return func() Payload { return reflect.Zero(t).Interface() }
+}
+
+// msgpackDecodeInto decodes b into p, non-pointer p(map, struct value...) is
+// decoded into a copy and returned.
+func msgpackDecodeInto(b []byte, p interface{}) (interface{}, error) {
+	v := reflect.ValueOf(p)
+	if v.Kind() == reflect.Ptr {
+		if err := msgpackUnmarshal(b, p); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+
+	nv := reflect.New(v.Type())
+	nv.Elem().Set(v)
+	if err := msgpackUnmarshal(b, nv.Interface()); err != nil {
+		return nil, err
+	}
+	return nv.Elem().Interface(), nil
+}
@@ -7,19 +7,25 @@ import (
 	"github.com/golang/protobuf/proto"
 )
 
-type protobufFactory struct{}
+type protobufFactory struct {
+	*RPCTypeRegistry
+}
 
 func NewProtobufFactory() RPCPayloadFactory {
 	pf := new(protobufFactory)
+	pf.RPCTypeRegistry = NewRPCTypeRegistry()
 	return RPCPayloadFactory(pf)
 }
 
 type protobufBuffer struct {
 	buf proto.Buffer
+
+	types *RPCTypeRegistry
 }
 
 func (pf *protobufFactory) NewBuffer() RPCPayloadBuffer {
 	pb := new(protobufBuffer)
+	pb.types = pf.RPCTypeRegistry
 
 	return RPCPayloadBuffer(pb)
 }
@@ -43,6 +49,24 @@ func (pb *protobufBuffer) Marshal(p Payload, b []byte) ([]byte, error) {
 	return pb.buf.Bytes(), nil
 }
 
-func (pb *protobufBuffer) Unmarshal(name string, b []byte) (Payload, error) {
-	return b, nil
+func (pb *protobufBuffer) Unmarshal(name string, request bool, b []byte) (Payload, error) {
+	p := pb.types.New(name, request)
+	if p == nil {
+		// unknown rpc, copy the raw bytes to upper level.
+		nb := make([]byte, len(b))
+		copy(nb, b)
+		return nb, nil
+	}
+
+	m, ok := p.(proto.Message)
+	if !ok {
+		return nil, ErrRPCPayloadType
+	}
+
+	pb.buf.SetBuf(b)
+	if err := pb.buf.Unmarshal(m); err != nil {
+		return nil, err
+	}
+
+	return p, nil
 }
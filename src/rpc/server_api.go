@@ -5,6 +5,7 @@ package rpc
 
 import (
 	"net"
+	"time"
 )
 
 // route()/hijack()
@@ -13,24 +14,66 @@ type ServeConn func(*Router, net.Conn) bool
 // FIXME: Payload -> bool
 type ServePayload func(*Router, string, Payload) Payload
 
+// RPCContext is the information of rpc request.
+type RPCContext struct {
+	Router   *Router
+	EP       string
+	RPC      string
+	Metadata Metadata
+	Priority Priority  // the reply inherits it
+	Peer     *Peer     // nil if it is unknown
+	Identity string    // set by Authenticator
+	Deadline time.Time // the deadline of caller, zero if it is unknown
+
+	start time.Time // admitted
+}
+
+// ServeRPC serves the request of one rpc, the error is sent to the caller as
+// status(see StatusOf).
+type ServeRPC func(*RPCContext, Payload) (Payload, error)
+
+// HandleRPC registers the handler of rpc, the rpc without handler is served
+// by ServePayload. It should be called before Run().
+func (r *Router) HandleRPC(rpc string, h ServeRPC) {
+	r.rpcServes[rpc] = h
+}
+
 // mock
 func serve_done(p Payload, arg RPCCallback_arg, err error) {
 	// Reclaim
 }
 
-func (rm *routeMsg) Serve(r *Router, ep_name string, rpc string, id uint64, p Payload) {
-	// TODO: Get the serve info
-	// rm.rpc is used to locate method
+func (rm *routeMsg) Serve(r *Router, ctx *RPCContext, id uint64, p Payload) {
+	var reply Payload
+	var err error
 
-	reply := r.serve(r, ep_name, p)
+	if err = r.authRequest(ctx, p); err != nil {
+		// rejected
+	} else if h, exist := r.rpcServes[ctx.RPC]; exist {
+		reply, err = h(ctx, p)
+	} else if r.serve != nil {
+		reply = r.serve(r, ctx.EP, p)
+	} else {
+		err = NewStatusError(STATUS_UNIMPLEMENTED, "unknown rpc "+ctx.RPC)
+	}
 
-	// TODO: nil?
-	out := r.serverOutMsgs.Get().(*routeMsg)
+	out, ok := r.serverOutMsgs.Get().(*routeMsg)
+	if !ok {
+		// the Router is stopped
+		return
+	}
 
-	out.ep_name = ep_name
-	out.rpc = rpc
+	out.Reset()
+	out.ep_name = ctx.EP
+	out.rpc = ctx.RPC
 	out.id = id
+	out.start = ctx.start
+	out.prio = ctx.Priority
 
+	if err != nil {
+		out.setStatusError(err)
+		reply = nil
+	}
 	out.p = reply
 
 	out.is_rpc = true
@@ -41,7 +84,7 @@ func (rm *routeMsg) Serve(r *Router, ep_name string, rpc string, id uint64, p Pa
 
 	// TODO: CAN NOT ACCESS OUT IN ROUTER GOROUTINE!
 	select {
-	case r.out <- out:
+	case r.outQueue(out) <- out:
 	default:
 		panic("routeMsg leaks?!")
 	}
@@ -0,0 +1,182 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrEndPointClosed error = &Error{err: "EndPoint closed"}
+)
+
+// Status is the result code of rpc, it is compatible with gRPC codes.
+type Status uint16
+
+const (
+	STATUS_OK Status = iota
+	STATUS_CANCELLED
+	STATUS_UNKNOWN
+	STATUS_INVALID_ARGUMENT
+	STATUS_DEADLINE_EXCEEDED
+	STATUS_NOT_FOUND
+	STATUS_ALREADY_EXISTS
+	STATUS_PERMISSION_DENIED
+	STATUS_RESOURCE_EXHAUSTED
+	STATUS_FAILED_PRECONDITION
+	STATUS_ABORTED
+	STATUS_OUT_OF_RANGE
+	STATUS_UNIMPLEMENTED
+	STATUS_INTERNAL
+	STATUS_UNAVAILABLE
+	STATUS_DATA_LOSS
+	STATUS_UNAUTHENTICATED
+)
+
+var statusNames = []string{
+	"OK",
+	"CANCELLED",
+	"UNKNOWN",
+	"INVALID_ARGUMENT",
+	"DEADLINE_EXCEEDED",
+	"NOT_FOUND",
+	"ALREADY_EXISTS",
+	"PERMISSION_DENIED",
+	"RESOURCE_EXHAUSTED",
+	"FAILED_PRECONDITION",
+	"ABORTED",
+	"OUT_OF_RANGE",
+	"UNIMPLEMENTED",
+	"INTERNAL",
+	"UNAVAILABLE",
+	"DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+func (s Status) String() string {
+	if int(s) < len(statusNames) {
+		return statusNames[s]
+	}
+	return fmt.Sprintf("STATUS(%d)", uint16(s))
+}
+
+// StatusError is the error with status code, it is sent to the caller if the
+// server returns it.
+type StatusError struct {
+	code Status
+	msg  string
+}
+
+func NewStatusError(code Status, msg string) error {
+	return &StatusError{code: code, msg: msg}
+}
+
+func (e *StatusError) Error() string {
+	if e.msg == "" {
+		return e.code.String()
+	}
+	return e.code.String() + ": " + e.msg
+}
+
+func (e *StatusError) Code() Status {
+	return e.code
+}
+
+func (e *StatusError) Message() string {
+	return e.msg
+}
+
+// StatusOf maps err to status code.
+func StatusOf(err error) Status {
+	switch err {
+	case nil:
+		return STATUS_OK
+	case ErrCallTimeout, ErrStreamTimeout, context.DeadlineExceeded:
+		return STATUS_DEADLINE_EXCEEDED
+	case context.Canceled:
+		return STATUS_CANCELLED
+	case ErrLimitExceeded, ErrStreamOverflow, ErrMessageTooLarge:
+		return STATUS_RESOURCE_EXHAUSTED
+	case ErrOutErrorEndPointNotExist, ErrEndPointClosed, ErrOPRouterStopped, ErrCircuitOpen:
+		return STATUS_UNAVAILABLE
+	}
+
+	if e, ok := err.(*StatusError); ok {
+		return e.code
+	}
+	return STATUS_UNKNOWN
+}
+
+// Metadata is the key/value pairs sent with rpc request or reply.
+type Metadata map[string]string
+
+// with returns a copy of md with key set to value.
+func (md Metadata) with(key string, value string) Metadata {
+	nmd := make(Metadata, len(md)+1)
+	for k, v := range md {
+		nmd[k] = v
+	}
+	nmd[key] = value
+	return nmd
+}
+
+const (
+	// The timeout of the call or stream in milliseconds, it is relative so
+	// the clocks of peers need not agree.
+	MetaTimeout = "rpc-timeout"
+)
+
+// withTimeout returns a copy of md with the timeout until to.
+func (md Metadata) withTimeout(to time.Time) Metadata {
+	ms := int64(time.Until(to) / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	return md.with(MetaTimeout, strconv.FormatInt(ms, 10))
+}
+
+// deadline returns the deadline of the timeout in md received at now, def if
+// there is none.
+func (md Metadata) deadline(now time.Time, def time.Time) time.Time {
+	ms, err := strconv.ParseInt(md[MetaTimeout], 10, 64)
+	if err != nil || ms <= 0 || ms > int64(math.MaxInt64/time.Millisecond) {
+		return def
+	}
+	return now.Add(time.Duration(ms) * time.Millisecond)
+}
+
+// MetaInfo is implemented by the rpc payload which carries status and
+// metadata.
+type MetaInfo interface {
+	GetStatus() (Status, string)
+	SetStatus(Status, string)
+	GetMetadata() Metadata
+	SetMetadata(Metadata)
+}
+
+type metaPayload struct {
+	md Metadata
+	p  Payload
+}
+
+// WithMetadata attaches md to p, it is sent with the rpc request.
+func WithMetadata(md Metadata, p Payload) Payload {
+	return &metaPayload{md: md, p: p}
+}
+
+func unwrapMetadata(p Payload) (Metadata, Payload) {
+	switch t := p.(type) {
+	case *metaPayload:
+		return t.md, t.p
+	case *codecPayload:
+		if mp, ok := t.p.(*metaPayload); ok {
+			return mp.md, WithCodec(t.codec, mp.p)
+		}
+	}
+	return nil, p
+}
@@ -0,0 +1,494 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+var (
+	ErrStreamTimeout  error = &Error{err: "Stream timeout"}
+	ErrStreamClosed   error = &Error{err: "Stream closed"}
+	ErrStreamOverflow error = &Error{err: "Stream receive queue overflow"}
+)
+
+// StreamInfo is implemented by the rpc payload which can be STREAM_DATA or
+// STREAM_END frame.
+type StreamInfo interface {
+	IsStream() bool
+	SetIsStream()
+	IsStreamEnd() bool
+	SetIsStreamEnd()
+}
+
+// ServeStream serves the stream opened by peer, the stream is closed for
+// sending after it returns.
+type ServeStream func(*Router, *Stream)
+
+type streamKey struct {
+	ep string
+	id uint64
+}
+
+// Stream is a sequence of messages in both directions identified by stream
+// id(rpcid). Send()/CloseSend() and Recv() can be used by two goroutines.
+type Stream struct {
+	r *Router
+
+	ep     string
+	rpc    string
+	id     uint64
+	client bool
+
+	tid TrackID
+	to  time.Time
+
+	md  Metadata        // the metadata sent(client) or received(server) with the first frame
+	ctx context.Context // NewStreamContext
+
+	in   chan Payload
+	done chan struct{}
+	err  error
+
+	// accessed inside router goroutine
+	send_closed bool
+	recv_closed bool
+	finished    bool
+
+	// accessed by sender
+	opened   bool
+	end_sent bool
+}
+
+func newStream(r *Router, ep string, rpc string, id uint64, client bool, to time.Time) *Stream {
+	s := new(Stream)
+
+	s.r = r
+	s.ep = ep
+	s.rpc = rpc
+	s.id = id
+	s.client = client
+	s.to = to
+
+	s.in = make(chan Payload, 1024)
+	s.done = make(chan struct{})
+
+	return s
+}
+
+func (s *Stream) GetEPName() string {
+	return s.ep
+}
+
+func (s *Stream) GetRPCName() string {
+	return s.rpc
+}
+
+// GetMetadata returns the metadata of the stream opened by peer, it is sent
+// with the first frame.
+func (s *Stream) GetMetadata() Metadata {
+	if s.client {
+		return nil
+	}
+	return s.md
+}
+
+// Send sends p as STREAM_DATA frame.
+func (s *Stream) Send(p Payload) error {
+	if s.end_sent {
+		return ErrStreamClosed
+	}
+
+	return s.send(p, false)
+}
+
+// CloseSend sends STREAM_END frame, the peer gets io.EOF after it receives
+// all messages.
+func (s *Stream) CloseSend() error {
+	return s.CloseSendError(nil)
+}
+
+// CloseSendError is CloseSend with the status of err(see StatusOf), the peer
+// fails the stream with it.
+func (s *Stream) CloseSendError(err error) error {
+	if s.end_sent {
+		return nil
+	}
+	s.end_sent = true
+
+	return s.sendStatus(nil, true, err)
+}
+
+// Recv returns the next message from peer, io.EOF if the peer closed the
+// stream normally.
+func (s *Stream) Recv() (Payload, error) {
+	select {
+	case p, ok := <-s.in:
+		if !ok {
+			return nil, io.EOF
+		}
+		return p, nil
+	case <-s.done:
+		// drain the received messages first
+		select {
+		case p, ok := <-s.in:
+			if ok {
+				return p, nil
+			}
+		default:
+		}
+
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+}
+
+func (s *Stream) send(p Payload, end bool) error {
+	return s.sendStatus(p, end, nil)
+}
+
+func (s *Stream) sendStatus(p Payload, end bool, err error) error {
+	select {
+	case <-s.done:
+		if s.err != nil {
+			return s.err
+		}
+		return ErrStreamClosed
+	default:
+	}
+
+	var pool *ResourceManager
+	if s.client {
+		pool = s.r.clientOutMsgs
+	} else {
+		pool = s.r.serverOutMsgs
+	}
+
+	var out *routeMsg
+	if v := pool.Get(); v == nil {
+		return ErrOPRouterStopped
+	} else {
+		out = v.(*routeMsg)
+	}
+
+	out.Reset()
+	out.ep_name = s.ep
+	out.rpc = s.rpc
+	out.id = s.id
+
+	out.is_rpc = true
+	out.is_request = s.client
+	out.stream = true
+	out.stream_end = end
+	if err != nil {
+		out.setStatusError(err)
+	}
+	if s.client && !s.opened {
+		// the server fails the stream at the same deadline.
+		out.md = s.md.withTimeout(s.to)
+	}
+	s.opened = true
+
+	out.p = p
+
+	out.r = s.r
+
+	select {
+	case s.r.outQueue(out) <- out:
+	default:
+		panic("routeMsg leaks?!")
+	}
+
+	return nil
+}
+
+func (s *Stream) When() time.Time {
+	return s.to
+}
+
+func (s *Stream) Timeout(now time.Time) {
+	s.r.stats.rpcTimeout++
+	// TimeoutTracker already removed it.
+	s.tid = InvalidTrackID
+	s.r.finishStream(s, ErrStreamTimeout)
+}
+
+// NewStream opens a stream to rpc of ep, the stream is failed with
+// ErrStreamTimeout if it is not finished in n seconds(0 means 5 minutes).
+func (r *Router) NewStream(ep string, rpc string, n time.Duration) (*Stream, error) {
+	if n < 0 {
+		return nil, ErrCallTimeout
+	} else if n == 0 {
+		n = 5 * time.Minute
+	} else {
+		n = n * time.Second
+	}
+
+	return r.newStream(context.Background(), ep, rpc, time.Now().Add(n), nil)
+}
+
+// NewStreamContext is NewStream which is failed with ctx.Err() when ctx is
+// done(5 minutes if no deadline), the peer gets the status of it.
+func (r *Router) NewStreamContext(ctx context.Context, ep string, rpc string) (*Stream, error) {
+	return r.NewStreamMetadata(ctx, ep, rpc, nil)
+}
+
+// NewStreamMetadata is NewStreamContext which sends md with the first frame,
+// the peer gets it by Stream.GetMetadata().
+func (r *Router) NewStreamMetadata(ctx context.Context, ep string, rpc string, md Metadata) (*Stream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	to, ok := ctx.Deadline()
+	if !ok {
+		to = time.Now().Add(5 * time.Minute)
+	}
+	s, err := r.newStream(ctx, ep, rpc, to, md)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				r.requestOP(RouterOPCancelStream, s)
+			case <-s.done:
+			}
+		}()
+	}
+
+	return s, nil
+}
+
+func (r *Router) newStream(ctx context.Context, ep string, rpc string, to time.Time, md Metadata) (*Stream, error) {
+	md, err := r.credentials(ep, rpc, nil, md)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newStream(r, ep, rpc, 0, true, to)
+	s.md = md
+	s.ctx = ctx
+
+	if v, err := r.requestOP(RouterOPAddStream, s); err != nil {
+		return nil, err
+	} else if v != nil {
+		return nil, v.(error)
+	}
+
+	return s, nil
+}
+
+// HandleStream registers the handler of streams opened to rpc. It should be
+// called before Run().
+func (r *Router) HandleStream(rpc string, h ServeStream) {
+	r.streamServes[rpc] = h
+}
+
+func (r *Router) addStream(s *Stream) error {
+	if ep, exist := r.nmap[s.ep]; exist && !ep.supports(FRAME_STREAM_DATA) {
+		return ErrFrameUnsupported
+	}
+
+	s.id = r.next
+	r.next++
+
+	if tid, err := r.tt.Add(s); err != nil {
+		return err
+	} else {
+		s.tid = tid
+	}
+	r.streams[s.id] = s
+
+	return nil
+}
+
+func (r *Router) serveStream(h ServeStream, s *Stream, ctx *RPCContext) {
+	if err := r.authRequest(ctx, nil); err != nil {
+		s.CloseSendError(err)
+		return
+	}
+
+	h(r, s)
+	s.CloseSend()
+}
+
+// lookupStream finds the stream of frame m, client sends request frames and
+// server replies.
+func (r *Router) lookupStream(m *routeMsg, client bool) *Stream {
+	if client {
+		return r.streams[m.id]
+	}
+	return r.sstreams[streamKey{ep: m.ep_name, id: m.id}]
+}
+
+// StreamOut returns false if the stream of m is already finished.
+func (r *Router) StreamOut(m *routeMsg) bool {
+	s := r.lookupStream(m, m.IsRequest())
+
+	if s == nil || s.send_closed {
+		return false
+	}
+
+	if m.IsStreamEnd() {
+		s.send_closed = true
+		if err := m.statusError(); err != nil {
+			// failed, the peer does not send any more.
+			r.finishStream(s, err)
+		} else {
+			r.tryFinishStream(s)
+		}
+	}
+
+	return true
+}
+
+func (r *Router) StreamOutError(m *routeMsg, err error) {
+	if s := r.lookupStream(m, m.IsRequest()); s != nil {
+		r.finishStream(s, err)
+	}
+}
+
+func (r *Router) StreamIn(m *routeMsg) {
+	s := r.lookupStream(m, !m.IsRequest())
+	if s == nil {
+		if !m.IsRequest() {
+			// timeout or cancel
+			return
+		} else if m.IsStreamEnd() && m.statusError() != nil {
+			// canceled before it is opened
+			return
+		}
+
+		h, exist := r.streamServes[m.rpc]
+		if !exist {
+			r.resetStream(m.ep_name, m.rpc, m.id, false, NewStatusError(STATUS_UNIMPLEMENTED, "unknown stream "+m.rpc))
+			return
+		}
+
+		now := time.Now()
+		s = newStream(r, m.ep_name, m.rpc, m.id, false, m.md.deadline(now, now.Add(5*time.Minute)))
+		s.md = m.md
+		if tid, err := r.tt.Add(s); err != nil {
+			r.resetStream(m.ep_name, m.rpc, m.id, false, err)
+			return
+		} else {
+			s.tid = tid
+		}
+		r.sstreams[streamKey{ep: s.ep, id: s.id}] = s
+
+		ctx := &RPCContext{Router: r, EP: m.ep_name, RPC: m.rpc, Metadata: m.md, Priority: m.prio, Deadline: s.to}
+		if ep, exist := r.nmap[m.ep_name]; exist {
+			ctx.Peer = ep.peer
+		}
+		go r.serveStream(h, s, ctx)
+	}
+
+	if m.IsStreamEnd() {
+		if err := m.statusError(); err != nil {
+			// the peer failed the stream, it may be closed for receiving
+			// already.
+			r.finishStream(s, err)
+			return
+		}
+	}
+
+	if s.recv_closed {
+		return
+	}
+
+	if !m.IsStreamEnd() {
+		// STREAM_END carries no message, the empty payload may be
+		// decoded as an empty message(protobuf).
+		select {
+		case s.in <- m.p:
+		default:
+			r.finishStream(s, ErrStreamOverflow)
+			r.resetStream(s.ep, s.rpc, s.id, s.client, ErrStreamOverflow)
+			return
+		}
+	}
+
+	if m.IsStreamEnd() {
+		s.recv_closed = true
+		close(s.in)
+		r.tryFinishStream(s)
+	}
+}
+
+// resetStream sends STREAM_END with the status of err to the peer, the peer
+// fails the stream.
+func (r *Router) resetStream(ep string, rpc string, id uint64, client bool, err error) {
+	pool := r.serverOutMsgs
+	if client {
+		pool = r.clientOutMsgs
+	}
+
+	// never wait inside router goroutine
+	var out *routeMsg
+	if v := pool.TryGet(); v == nil {
+		return
+	} else {
+		out = v.(*routeMsg)
+	}
+
+	out.Reset()
+	out.ep_name = ep
+	out.rpc = rpc
+	out.id = id
+	out.is_rpc = true
+	out.is_request = client
+	out.stream = true
+	out.stream_end = true
+	out.setStatusError(err)
+	out.r = r
+
+	r.stats.msgOut++
+	r.writeOut(out)
+}
+
+// cancelStream fails the stream of NewStreamContext with ctx.Err(), the peer
+// gets the status of it.
+func (r *Router) cancelStream(s *Stream) {
+	if s.finished {
+		return
+	}
+
+	err := s.ctx.Err()
+	r.finishStream(s, err)
+	r.resetStream(s.ep, s.rpc, s.id, s.client, err)
+}
+
+func (r *Router) tryFinishStream(s *Stream) {
+	if s.send_closed && s.recv_closed {
+		r.finishStream(s, nil)
+	}
+}
+
+func (r *Router) finishStream(s *Stream, err error) {
+	if s.finished {
+		return
+	}
+	s.finished = true
+
+	if s.client {
+		delete(r.streams, s.id)
+	} else {
+		delete(r.sstreams, streamKey{ep: s.ep, id: s.id})
+	}
+
+	if s.tid != InvalidTrackID {
+		r.tt.Del(s.tid)
+		s.tid = InvalidTrackID
+	}
+
+	s.err = err
+	close(s.done)
+}
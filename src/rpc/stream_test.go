@@ -0,0 +1,255 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func StreamEcho(r *Router, s *Stream) {
+	for {
+		p, err := s.Recv()
+		if err != nil {
+			return
+		}
+		req := p.(*jsonReq)
+		if err := s.Send(&jsonResp{Id: req.Id}); err != nil {
+			return
+		}
+	}
+}
+
+func StreamSilent(r *Router, s *Stream) {
+	time.Sleep(3 * time.Second)
+}
+
+func TestRouterStream(t *testing.T) {
+	r, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+
+	pf := NewJSONFactory()
+	pf.Register("echo", func() Payload { return new(jsonReq) }, func() Payload { return new(jsonResp) })
+	pf.Register("silent", func() Payload { return new(jsonReq) }, func() Payload { return new(jsonResp) })
+	pf.Register("none", func() Payload { return new(jsonReq) }, func() Payload { return new(jsonResp) })
+	hf := NewRPCHeaderFactory(pf)
+
+	r.HandleStream("echo", StreamEcho)
+	r.HandleStream("silent", StreamSilent)
+
+	name := "scheduler"
+	network := "tcp"
+	address := "localhost:10007"
+
+	r.Run()
+	defer r.Stop()
+
+	if err := r.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := r.Dial(name, network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	s, err := r.NewStream(name, "echo", 5)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	n := 100
+	for i := 0; i < n; i++ {
+		if err := s.Send(&jsonReq{Id: uint64(i)}); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+	if err := s.CloseSend(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := s.Send(&jsonReq{}); err != ErrStreamClosed {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	for i := 0; i < n; i++ {
+		if p, err := s.Recv(); err != nil {
+			t.Log(err)
+			t.FailNow()
+		} else if resp, ok := p.(*jsonResp); !ok || resp.Id != uint64(i) {
+			t.Log(p)
+			t.FailNow()
+		}
+	}
+	if _, err := s.Recv(); err != io.EOF {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	s, err = r.NewStream(name, "silent", 1)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := s.Send(&jsonReq{Id: 1}); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := s.Recv(); err != ErrStreamTimeout {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// no handler
+	s, err = r.NewStream(name, "none", 5)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := s.Send(&jsonReq{Id: 1}); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := s.Recv(); StatusOf(err) != STATUS_UNIMPLEMENTED {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// the receive queue of peer overflows
+	s, err = r.NewStream(name, "silent", 5)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	for i := 0; i < 2048; i++ {
+		if err := s.Send(&jsonReq{Id: uint64(i)}); err != nil {
+			break
+		}
+	}
+	if _, err := s.Recv(); StatusOf(err) != STATUS_RESOURCE_EXHAUSTED {
+		t.Log(err)
+		t.FailNow()
+	}
+}
+
+func TestRouterStreamContext(t *testing.T) {
+	r, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+
+	pf := NewJSONFactory()
+	pf.Register("wait", func() Payload { return new(jsonReq) }, func() Payload { return new(jsonResp) })
+	hf := NewRPCHeaderFactory(pf)
+
+	// the error of server side
+	errc := make(chan error, 1)
+	r.HandleStream("wait", func(r *Router, s *Stream) {
+		var err error
+		for err == nil {
+			_, err = s.Recv()
+		}
+		if err == io.EOF {
+			// closed for receiving, send until it is canceled
+			for err = nil; err == nil; err = s.Send(&jsonResp{}) {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		errc <- err
+	})
+
+	name := "scheduler"
+	network := "tcp"
+	address := "localhost:10028"
+
+	r.Run()
+	defer r.Stop()
+
+	if err := r.ListenAndServe("client", network, address, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := r.Dial(name, network, address, hf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	for _, closeSend := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		s, err := r.NewStreamContext(ctx, name, "wait")
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if err := s.Send(&jsonReq{Id: 1}); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if closeSend {
+			s.CloseSend()
+		}
+
+		// the blocked Recv returns when ctx is canceled
+		time.AfterFunc(20*time.Millisecond, cancel)
+		start := time.Now()
+		for err == nil {
+			_, err = s.Recv()
+		}
+		if StatusOf(err) != STATUS_CANCELLED || time.Since(start) > time.Second {
+			t.Log(closeSend, err, time.Since(start))
+			t.FailNow()
+		}
+
+		select {
+		case err := <-errc:
+			if StatusOf(err) != STATUS_CANCELLED {
+				t.Log(closeSend, err)
+				t.FailNow()
+			}
+		case <-time.After(time.Second):
+			t.Log(closeSend, "the server stream is not canceled")
+			t.FailNow()
+		}
+	}
+
+	// the server stream fails at the deadline of client
+	s, err := r.NewStream(name, "wait", 1)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := s.Send(&jsonReq{Id: 1}); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	select {
+	case err := <-errc:
+		if StatusOf(err) != STATUS_DEADLINE_EXCEEDED {
+			t.Log(err)
+			t.FailNow()
+		}
+	case <-time.After(3 * time.Second):
+		t.Log("the server stream does not time out")
+		t.FailNow()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	s, err = r.NewStreamContext(ctx, name, "wait")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if _, err := s.Recv(); StatusOf(err) != STATUS_DEADLINE_EXCEEDED {
+		t.Log(err)
+		t.FailNow()
+	}
+}
@@ -36,6 +36,7 @@ type TimeoutTracker struct {
 	idx   map[TrackID]*LinkNode   // fast remove index
 	ridx  map[*LinkNode]TrackID   // fast remove index
 	tslot map[time.Time]*LinkNode // fast insert
+	slot  map[TrackID]time.Time   // the slot of object
 
 	free *LinkNode
 }
@@ -67,6 +68,7 @@ func NewTimeoutTracker(ms int, max int) (*TimeoutTracker, error) {
 	tt.idx = make(map[TrackID]*LinkNode)
 	tt.ridx = make(map[*LinkNode]TrackID)
 	tt.tslot = make(map[time.Time]*LinkNode)
+	tt.slot = make(map[TrackID]time.Time)
 
 	return tt, nil
 }
@@ -102,6 +104,7 @@ func (tt *TimeoutTracker) TimeoutCheck(now time.Time) {
 				}
 				delete(tt.idx, id)
 				delete(tt.ridx, n)
+				delete(tt.slot, id)
 				tt.free.InsertAfter(tt.free.Tail(), n)
 			}
 			// TODO: reuse Link in the future
@@ -111,11 +114,19 @@ func (tt *TimeoutTracker) TimeoutCheck(now time.Time) {
 }
 
 func (tt *TimeoutTracker) Add(o TrackObject) (TrackID, error) {
+	// round up, o never times out before When().
 	t := o.When().Truncate(tt.delta)
+	if t.Before(o.When()) {
+		t = t.Add(tt.delta)
+	}
 
 	if t.Before(tt.last) {
 		o.Timeout(tt.last)
 		return InvalidTrackID, ErrTimeout
+	} else if t.Equal(tt.last) {
+		// the slot of tt.last is already checked, it times out at the
+		// next tick.
+		t = tt.last.Add(tt.delta)
 	}
 
 	if tt.free.Empty() {
@@ -134,6 +145,7 @@ func (tt *TimeoutTracker) Add(o TrackObject) (TrackID, error) {
 
 	tt.idx[id] = tt.free.Head().Remove(tt.free.Head()).Set(o)
 	tt.ridx[tt.idx[id]] = id
+	tt.slot[id] = t
 	l.InsertAfter(l.Tail(), tt.idx[id])
 
 	return id, nil
@@ -145,11 +157,13 @@ func (tt *TimeoutTracker) Del(id TrackID) TrackObject {
 		return nil
 	}
 
+	t := tt.slot[id]
 	delete(tt.idx, id)
 	delete(tt.ridx, n)
+	delete(tt.slot, id)
 	o := n.v.(TrackObject)
 
-	l, exist := tt.tslot[o.When().Truncate(tt.delta)]
+	l, exist := tt.tslot[t]
 	if !exist {
 		panic("TimeoutTracker data inconsistent")
 	}
@@ -159,7 +173,7 @@ func (tt *TimeoutTracker) Del(id TrackID) TrackObject {
 
 	if l.Empty() {
 		// TODO: reuse Link in the future
-		delete(tt.tslot, o.When().Truncate(tt.delta))
+		delete(tt.tslot, t)
 	}
 
 	return o
@@ -127,3 +127,51 @@ func TestTimeoutTracker(t *testing.T) {
 
 	tt.Stop()
 }
+
+type countTimeoutObject struct {
+	to time.Time
+	n  int
+}
+
+func (cto *countTimeoutObject) When() time.Time {
+	return cto.to
+}
+
+func (cto *countTimeoutObject) Timeout(now time.Time) {
+	cto.n++
+}
+
+func TestTimeoutTrackerCheckedSlot(t *testing.T) {
+	delta := 100
+	tt, _ := NewTimeoutTracker(delta, 1024)
+	defer tt.Stop()
+
+	// the slot of tt.last is checked, it times out at the next tick.
+	o1 := &countTimeoutObject{to: tt.last.Add(10 * time.Millisecond)}
+	o2 := &countTimeoutObject{to: tt.last.Add(20 * time.Millisecond)}
+	id1, err := tt.Add(o1)
+	if err != nil || o1.n != 0 {
+		t.Log(err, o1.n)
+		t.FailNow()
+	}
+	if _, err := tt.Add(o2); err != nil || o2.n != 0 {
+		t.Log(err, o2.n)
+		t.FailNow()
+	}
+	if tt.Del(id1) != o1 {
+		t.FailNow()
+	}
+
+	tt.TimeoutCheck(tt.last.Add(time.Duration(delta) * time.Millisecond))
+	if o1.n != 0 || o2.n != 1 {
+		t.Log(o1.n, o2.n)
+		t.FailNow()
+	}
+
+	// the passed slot times out at once
+	o3 := &countTimeoutObject{to: tt.last.Add(-time.Duration(delta) * time.Millisecond)}
+	if _, err := tt.Add(o3); err != ErrTimeout || o3.n != 1 {
+		t.Log(err, o3.n)
+		t.FailNow()
+	}
+}
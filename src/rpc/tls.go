@@ -0,0 +1,127 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"time"
+)
+
+var (
+	ErrTLSConfig error = &Error{err: "TLS config is nil"}
+)
+
+// Peer is the identity of the remote side of EndPoint.
+type Peer struct {
+	// Verified certificate of TLS peer, nil if it is not verified(or not
+	// TLS).
+	Certificate *x509.Certificate
+	// SNI of TLS connection.
+	ServerName string
+	// Credentials of Unix socket peer, nil if it is not supported.
+	Cred *PeerCred
+}
+
+// GetName returns the common name of verified certificate.
+func (p *Peer) GetName() string {
+	if p == nil || p.Certificate == nil {
+		return ""
+	}
+	return p.Certificate.Subject.CommonName
+}
+
+// Peer returns the identity of remote side, nil if it is unknown.
+func (ep *EndPoint) Peer() *Peer {
+	return ep.peer
+}
+
+// tlsHandshakeTimeout limits the handshake of accepted connections.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// tlsPeer finishes the handshake of TLS connection c and returns the peer.
+func tlsPeer(c *tls.Conn) (*Peer, error) {
+	c.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := c.Handshake(); err != nil {
+		return nil, err
+	}
+	c.SetDeadline(time.Time{})
+
+	st := c.ConnectionState()
+	p := &Peer{ServerName: st.ServerName}
+	// VerifiedChains is empty if the certificate is not verified.
+	if len(st.VerifiedChains) > 0 && len(st.PeerCertificates) > 0 {
+		p.Certificate = st.PeerCertificates[0]
+	}
+	return p, nil
+}
+
+// newConnEndPoint creates the EndPoint of connection c, the handshake of TLS
+// connection is finished first, then the EndPoint is authenticated.
+func (r *Router) newConnEndPoint(name string, c net.Conn, mf MsgFactory) (*EndPoint, error) {
+	var peer *Peer
+	switch t := c.(type) {
+	case *tls.Conn:
+		if p, err := tlsPeer(t); err != nil {
+			return nil, err
+		} else {
+			peer = p
+		}
+	case *net.UnixConn:
+		if cred, err := peerCred(t); err != nil {
+			return nil, err
+		} else if cred != nil {
+			peer = &Peer{Cred: cred}
+		}
+	}
+
+	ep := r.newRouterEndPoint(name, c, mf)
+	if ep == nil {
+		return nil, nil
+	}
+	ep.peer = peer
+
+	if err := r.authEndPoint(ep); err != nil {
+		return nil, err
+	}
+	return ep, nil
+}
+
+// DialTLS is Dial over TLS. config.Certificates is the client certificate of
+// mutual TLS, config.ServerName is the SNI(the host of address by default),
+// config.VerifyConnection is the custom verification hook.
+func (r *Router) DialTLS(name string, network string, address string, mf MsgFactory, config *tls.Config) error {
+	if config == nil {
+		return ErrTLSConfig
+	}
+
+	c, err := tls.Dial(network, address, config)
+	if err != nil {
+		return err
+	}
+
+	return r.addConn(name, c, mf)
+}
+
+// ListenAndServeTLS is ListenAndServe over TLS. Set config.ClientAuth to
+// tls.RequireAndVerifyClientCert with config.ClientCAs for mutual TLS,
+// config.GetCertificate selects the certificate by SNI.
+func (r *Router) ListenAndServeTLS(name string, network string, address string, mf MsgFactory, server ServeConn, config *tls.Config) error {
+	if config == nil {
+		return ErrTLSConfig
+	}
+
+	if nl, err := net.Listen(network, address); err != nil {
+		return err
+	} else if l, err := NewListener(name, tls.NewListener(nl, config), mf, r, server); err != nil {
+		nl.Close()
+		return err
+	} else if err := r.AddListener(l); err != nil {
+		l.Stop()
+		return err
+	}
+
+	return nil
+}
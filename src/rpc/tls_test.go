@@ -0,0 +1,162 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert tls.Certificate
+	x509 *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert creates the certificate of cn signed by ca, it is self-signed
+// CA if ca is nil.
+func newTestCert(t *testing.T, cn string, ca *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	parent, pkey := tmpl, key
+	if ca == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, pkey = ca.x509, ca.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, pkey)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	return &testCert{cert: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: c}, x509: c, key: key}
+}
+
+func TestRouterTLS(t *testing.T) {
+	pf := NewJSONFactory()
+	hf := NewRPCHeaderFactory(pf)
+
+	network := "tcp"
+	address := "localhost:10020"
+
+	ca := newTestCert(t, "ca", nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.x509)
+
+	var sni string
+	sconfig := &tls.Config{
+		Certificates: []tls.Certificate{newTestCert(t, "server.test", ca).cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    roots,
+		VerifyConnection: func(st tls.ConnectionState) error {
+			sni = st.ServerName
+			return nil
+		},
+	}
+
+	server, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	server.HandleRPC("whoami", func(ctx *RPCContext, p Payload) (Payload, error) {
+		if name := ctx.Peer.GetName(); name != "client" {
+			return nil, NewStatusError(STATUS_UNAUTHENTICATED, name)
+		}
+		return p, nil
+	})
+	server.Run()
+	defer server.Stop()
+
+	client, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	client.Run()
+	defer client.Stop()
+
+	if err := server.ListenAndServeTLS("client", network, address, hf, ServiceProcessConn, sconfig); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// the server is not trusted.
+	if err := client.DialTLS("untrusted", network, address, hf, &tls.Config{ServerName: "server.test"}); err == nil {
+		t.FailNow()
+	}
+
+	// the stalled handshake does not block others.
+	stalled, err := net.Dial(network, address)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer stalled.Close()
+	start := time.Now()
+
+	cconfig := &tls.Config{
+		Certificates: []tls.Certificate{newTestCert(t, "client", ca).cert},
+		RootCAs:      roots,
+		ServerName:   "server.test",
+	}
+	if err := client.DialTLS("server", network, address, hf, cconfig); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if _, err := client.CallWait("server", "whoami", nil, 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if time.Since(start) > time.Second {
+		t.Log(time.Since(start))
+		t.FailNow()
+	}
+	if sni != "server.test" {
+		t.Log(sni)
+		t.FailNow()
+	}
+
+	v, _ := client.requestOP(RouterOPDelEndPoint, "server")
+	ep, ok := v.(*EndPoint)
+	if !ok {
+		t.Log(v)
+		t.FailNow()
+	}
+	defer ep.Stop()
+	if ep.Peer().GetName() != "server.test" {
+		t.Log(ep.Peer())
+		t.FailNow()
+	}
+}
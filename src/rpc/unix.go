@@ -0,0 +1,126 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnixSocketInUse error = &Error{err: "Unix socket is in use"}
+	ErrNotUnixSocket   error = &Error{err: "File exists and is not a socket"}
+)
+
+// PeerCred is the credentials of the process of Unix socket peer(SO_PEERCRED).
+type PeerCred struct {
+	Uid uint32
+	Gid uint32
+	Pid int32
+}
+
+// abstract returns true if path is the Linux abstract socket name("@name").
+func abstract(path string) bool {
+	return strings.HasPrefix(path, "@")
+}
+
+// removeStaleSocket removes the socket file of path if nobody listens on it.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return ErrNotUnixSocket
+	}
+
+	if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		c.Close()
+		return ErrUnixSocketInUse
+	}
+
+	return os.Remove(path)
+}
+
+// unixListener removes the socket file which is renamed when it is closed.
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+// listenUnix listens on path, the socket is bound in a private directory and
+// chmod to perm before it is renamed to path, so it is never accessible with
+// the default permission.
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if abstract(path) || perm == 0 {
+		return net.Listen("unix", path)
+	}
+
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".rpc")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "s")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	l.SetUnlinkOnClose(false)
+
+	if err := os.Chmod(tmp, perm); err != nil {
+		l.Close()
+		return nil, err
+	} else if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return &unixListener{UnixListener: l, path: path}, nil
+}
+
+// DialUnix is Dial to Unix socket path, "@name" is the abstract socket.
+func (r *Router) DialUnix(name string, path string, mf MsgFactory) error {
+	return r.Dial(name, "unix", path, mf)
+}
+
+// ListenAndServeUnix is ListenAndServe on Unix socket path, "@name" is the
+// abstract socket. The stale socket file is removed first, the socket file is
+// created with perm if it is not 0, it is removed when the Listener is closed.
+func (r *Router) ListenAndServeUnix(name string, path string, perm os.FileMode, mf MsgFactory, server ServeConn) error {
+	if !abstract(path) {
+		if err := removeStaleSocket(path); err != nil {
+			return err
+		}
+	}
+
+	nl, err := listenUnix(path, perm)
+	if err != nil {
+		return err
+	}
+
+	if l, err := NewListener(name, nl, mf, r, server); err != nil {
+		nl.Close()
+		return err
+	} else if err := r.AddListener(l); err != nil {
+		l.Stop()
+		return err
+	}
+
+	return nil
+}
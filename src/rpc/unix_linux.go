@@ -0,0 +1,32 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+//go:build linux
+// +build linux
+
+package rpc
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCred returns SO_PEERCRED of Unix socket c.
+func peerCred(c *net.UnixConn) (*PeerCred, error) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *syscall.Ucred
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		cred, serr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	} else if serr != nil {
+		return nil, serr
+	}
+
+	return &PeerCred{Uid: cred.Uid, Gid: cred.Gid, Pid: cred.Pid}, nil
+}
@@ -0,0 +1,16 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+//go:build !linux
+// +build !linux
+
+package rpc
+
+import (
+	"net"
+)
+
+// peerCred is not supported.
+func peerCred(c *net.UnixConn) (*PeerCred, error) {
+	return nil, nil
+}
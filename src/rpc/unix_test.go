@@ -0,0 +1,117 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRouterUnix(t *testing.T) {
+	pf := NewJSONFactory()
+	hf := NewRPCHeaderFactory(pf)
+
+	dir, err := ioutil.TempDir("", "rpc")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rpc.sock")
+
+	// stale socket file
+	if l, err := net.Listen("unix", path); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else {
+		l.(*net.UnixListener).SetUnlinkOnClose(false)
+		l.Close()
+	}
+
+	server, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	server.HandleRPC("whoami", func(ctx *RPCContext, p Payload) (Payload, error) {
+		if runtime.GOOS != "linux" {
+			return p, nil
+		}
+		if ctx.Peer == nil || ctx.Peer.Cred == nil {
+			return nil, NewStatusError(STATUS_UNAUTHENTICATED, "")
+		}
+		if c := ctx.Peer.Cred; int(c.Uid) != os.Getuid() || int(c.Gid) != os.Getgid() || int(c.Pid) != os.Getpid() {
+			return nil, NewStatusError(STATUS_PERMISSION_DENIED, "")
+		}
+		return p, nil
+	})
+	server.Run()
+	defer server.Stop()
+
+	if err := server.ListenAndServeUnix("client", path, 0600, hf, ServiceProcessConn); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Log(fi, err)
+		t.FailNow()
+	}
+	// the private directory is removed
+	if fis, err := ioutil.ReadDir(dir); err != nil || len(fis) != 1 {
+		t.Log(fis, err)
+		t.FailNow()
+	}
+
+	// in use
+	if err := server.ListenAndServeUnix("other", path, 0, hf, ServiceProcessConn); err != ErrUnixSocketInUse {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	addresses := []string{path}
+	if runtime.GOOS == "linux" {
+		abs := "@rpc-test-" + strconv.Itoa(os.Getpid())
+		if err := server.ListenAndServeUnix("abstract", abs, 0, hf, ServiceProcessConn); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		addresses = append(addresses, abs)
+	}
+
+	for _, address := range addresses {
+		// 2 connections of the same listener
+		for i := 0; i < 2; i++ {
+			client, err := NewRouter(nil, JSONProcessPayload)
+			if err != nil {
+				t.FailNow()
+			}
+			client.Run()
+			defer client.Stop()
+
+			if err := client.DialUnix("server", address, hf); err != nil {
+				t.Log(err)
+				t.FailNow()
+			}
+			if _, err := client.CallWait("server", "whoami", nil, 5); err != nil {
+				t.Log(address, err)
+				t.FailNow()
+			}
+		}
+	}
+
+	// the socket file is removed with the Listener
+	if err := server.DelListener("client"); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Log(err)
+		t.FailNow()
+	}
+}
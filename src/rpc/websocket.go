@@ -0,0 +1,514 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrWebSocketHandshake error = &Error{err: "WebSocket handshake failed"}
+	ErrWebSocketProtocol  error = &Error{err: "WebSocket protocol error"}
+	ErrWebSocketClosed    error = &Error{err: "WebSocket closed"}
+)
+
+// WebSocket(RFC 6455) frame
+const (
+	ws_fin  = 1 << 7
+	ws_rsv  = 0x70
+	ws_mask = 1 << 7
+
+	ws_op_continuation = 0x0
+	ws_op_text         = 0x1
+	ws_op_binary       = 0x2
+	ws_op_close        = 0x8
+	ws_op_ping         = 0x9
+	ws_op_pong         = 0xA
+	ws_op_control      = 0x8
+
+	ws_max_control_len = 125
+	ws_close_normal    = 1000
+
+	wsGUID     = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsProtocol = "lrpc"
+)
+
+// The ping interval, the connection is closed if nothing is received in 2
+// intervals.
+var wsPingInterval = 30 * time.Second
+
+// wsConn is net.Conn of WebSocket, the bytes are sent as binary messages.
+type wsConn struct {
+	net.Conn
+	br *bufio.Reader
+
+	// client masks the frames
+	client bool
+
+	interval time.Duration // ping
+
+	// the current data frame
+	remain int64
+	masked bool
+	key    [4]byte
+	pos    int
+	// the final frame of current message is not received
+	fragmented bool
+
+	wmu       sync.Mutex
+	closeSent bool
+
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newWSConn(c net.Conn, br *bufio.Reader, client bool) *wsConn {
+	ws := &wsConn{Conn: c, br: br, client: client, interval: wsPingInterval, closed: make(chan struct{})}
+	go ws.pinger()
+	return ws
+}
+
+// wsAccept returns Sec-WebSocket-Accept of key.
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerContains returns true if the comma separated header k has token v.
+func headerContains(h http.Header, k string, v string) bool {
+	for _, s := range h[http.CanonicalHeaderKey(k)] {
+		for _, t := range strings.Split(s, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ws *wsConn) pinger() {
+	t := time.NewTicker(ws.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ws.closed:
+			return
+		case <-t.C:
+			if err := ws.writeFrame(ws_op_ping, nil); err != nil {
+				ws.Close()
+				return
+			}
+		}
+	}
+}
+
+func (ws *wsConn) writeFrame(op byte, p []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+
+	if ws.closeSent {
+		return ErrWebSocketClosed
+	}
+	if op == ws_op_close {
+		ws.closeSent = true
+	}
+
+	b := make([]byte, 2, 14+len(p))
+	b[0] = ws_fin | op
+	switch n := len(p); {
+	case n <= ws_max_control_len:
+		b[1] = byte(n)
+	case n <= 0xFFFF:
+		b[1] = 126
+		b = append(b, 0, 0)
+		binary.BigEndian.PutUint16(b[2:], uint16(n))
+	default:
+		b[1] = 127
+		b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(b[2:], uint64(n))
+	}
+
+	if ws.client {
+		b[1] |= ws_mask
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		b = append(b, key[:]...)
+		off := len(b)
+		b = append(b, p...)
+		for i := range p {
+			b[off+i] ^= key[i%4]
+		}
+	} else {
+		b = append(b, p...)
+	}
+
+	_, err := ws.Conn.Write(b)
+	return err
+}
+
+// nextFrame reads the frames until the next data frame, the control frames
+// are handled.
+func (ws *wsConn) nextFrame() error {
+	for {
+		ws.Conn.SetReadDeadline(time.Now().Add(2 * ws.interval))
+
+		var h [2]byte
+		if _, err := io.ReadFull(ws.br, h[:]); err != nil {
+			return err
+		}
+
+		op := h[0] & 0xF
+		masked := h[1]&ws_mask != 0
+		n := int64(h[1] &^ ws_mask)
+		// the frames of client are masked, the server's are not. No
+		// extension is negotiated, the RSV bits must be 0.
+		if masked == ws.client || h[0]&ws_rsv != 0 {
+			return ErrWebSocketProtocol
+		}
+		switch op {
+		case ws_op_continuation, ws_op_text, ws_op_binary, ws_op_close, ws_op_ping, ws_op_pong:
+		default:
+			// reserved
+			return ErrWebSocketProtocol
+		}
+
+		switch n {
+		case 126:
+			var b [2]byte
+			if _, err := io.ReadFull(ws.br, b[:]); err != nil {
+				return err
+			}
+			n = int64(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			if _, err := io.ReadFull(ws.br, b[:]); err != nil {
+				return err
+			}
+			n = int64(binary.BigEndian.Uint64(b[:]))
+			if n < 0 {
+				// the most significant bit must be 0
+				return ErrWebSocketProtocol
+			}
+		}
+
+		var key [4]byte
+		if masked {
+			if _, err := io.ReadFull(ws.br, key[:]); err != nil {
+				return err
+			}
+		}
+
+		if op&ws_op_control == 0 {
+			switch {
+			case op == ws_op_text:
+				// only binary messages
+				return ErrWebSocketProtocol
+			case op == ws_op_continuation && !ws.fragmented:
+				// no message to continue
+				return ErrWebSocketProtocol
+			case op == ws_op_binary && ws.fragmented:
+				// the current message is not finished
+				return ErrWebSocketProtocol
+			}
+			ws.fragmented = h[0]&ws_fin == 0
+			ws.remain, ws.masked, ws.key, ws.pos = n, masked, key, 0
+			return nil
+		}
+
+		if n > ws_max_control_len || h[0]&ws_fin == 0 {
+			return ErrWebSocketProtocol
+		}
+		p := make([]byte, n)
+		if _, err := io.ReadFull(ws.br, p); err != nil {
+			return err
+		}
+		if masked {
+			for i := range p {
+				p[i] ^= key[i%4]
+			}
+		}
+
+		switch op {
+		case ws_op_ping:
+			if err := ws.writeFrame(ws_op_pong, p); err != nil {
+				return err
+			}
+		case ws_op_pong:
+			// alive
+		case ws_op_close:
+			// echo the status code
+			if len(p) > 2 {
+				p = p[:2]
+			}
+			ws.writeFrame(ws_op_close, p)
+			return io.EOF
+		default:
+			return ErrWebSocketProtocol
+		}
+	}
+}
+
+func (ws *wsConn) Read(b []byte) (int, error) {
+	for ws.remain == 0 {
+		if err := ws.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(b)) > ws.remain {
+		b = b[:ws.remain]
+	}
+	// the large frame may take a while
+	ws.Conn.SetReadDeadline(time.Now().Add(2 * ws.interval))
+	n, err := ws.br.Read(b)
+	if ws.masked {
+		for i := 0; i < n; i++ {
+			b[i] ^= ws.key[(ws.pos+i)%4]
+		}
+		ws.pos = (ws.pos + n) % 4
+	}
+	ws.remain -= int64(n)
+	return n, err
+}
+
+// Write sends b as one binary message.
+func (ws *wsConn) Write(b []byte) (int, error) {
+	if err := ws.writeFrame(ws_op_binary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close sends the close frame and closes the connection.
+// TODO: wait the close frame of peer?
+func (ws *wsConn) Close() error {
+	var err error
+	ws.once.Do(func() {
+		close(ws.closed)
+
+		var p [2]byte
+		binary.BigEndian.PutUint16(p[:], ws_close_normal)
+		ws.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+		ws.writeFrame(ws_op_close, p[:])
+
+		err = ws.Conn.Close()
+	})
+	return err
+}
+
+// dialWebSocket connects to the WebSocket server of u("ws://" or "wss://").
+func dialWebSocket(u string, config *tls.Config) (*wsConn, error) {
+	wu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+
+	var c net.Conn
+	switch wu.Scheme {
+	case "ws":
+		host := wu.Host
+		if wu.Port() == "" {
+			host = net.JoinHostPort(wu.Hostname(), "80")
+		}
+		c, err = net.Dial("tcp", host)
+	case "wss":
+		host := wu.Host
+		if wu.Port() == "" {
+			host = net.JoinHostPort(wu.Hostname(), "443")
+		}
+		c, err = tls.Dial("tcp", host, config)
+	default:
+		return nil, ErrWebSocketHandshake
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ws, err := wsClientHandshake(c, wu)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
+func wsClientHandshake(c net.Conn, u *url.URL) (*wsConn, error) {
+	c.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	defer c.SetDeadline(time.Time{})
+
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req := &http.Request{
+		Method:     "GET",
+		URL:        &url.URL{Path: u.Path, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Protocol", wsProtocol)
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	if err := req.Write(c); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!headerContains(resp.Header, "Upgrade", "websocket") ||
+		!headerContains(resp.Header, "Connection", "upgrade") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		return nil, ErrWebSocketHandshake
+	}
+
+	return newWSConn(c, br, true), nil
+}
+
+// wsListener is the net.Listener of WebSocket connections upgraded by
+// ServeHTTP.
+type wsListener struct {
+	l net.Listener
+	s *http.Server
+
+	conns  chan *wsConn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newWSListener(l net.Listener) *wsListener {
+	return &wsListener{l: l, conns: make(chan *wsConn), closed: make(chan struct{})}
+}
+
+func (l *wsListener) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if !headerContains(req.Header, "Upgrade", "websocket") || !headerContains(req.Header, "Connection", "upgrade") || key == "" {
+		http.Error(w, "not WebSocket handshake", http.StatusBadRequest)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijack is not supported", http.StatusInternalServerError)
+		return
+	}
+	c, brw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	brw.WriteString("Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n")
+	if headerContains(req.Header, "Sec-WebSocket-Protocol", wsProtocol) {
+		brw.WriteString("Sec-WebSocket-Protocol: " + wsProtocol + "\r\n")
+	}
+	brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		c.Close()
+		return
+	}
+
+	ws := newWSConn(c, brw.Reader, false)
+	select {
+	case l.conns <- ws:
+	case <-l.closed:
+		ws.Close()
+	}
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, ErrWebSocketClosed
+	}
+}
+
+func (l *wsListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+		l.s.Close()
+	})
+	return nil
+}
+
+func (l *wsListener) Addr() net.Addr {
+	return l.l.Addr()
+}
+
+// DialWebSocket is Dial over WebSocket u("ws://host:port/path" or "wss://"),
+// the messages are sent as binary WebSocket messages. config is used by
+// "wss://".
+func (r *Router) DialWebSocket(name string, u string, mf MsgFactory, config *tls.Config) error {
+	c, err := dialWebSocket(u, config)
+	if err != nil {
+		return err
+	}
+
+	return r.addConn(name, c, mf)
+}
+
+// ListenWebSocket is ListenAndServe over WebSocket, the HTTP server on
+// address upgrades the requests of path.
+func (r *Router) ListenWebSocket(name string, network string, address string, path string, mf MsgFactory, server ServeConn) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+
+	wl := newWSListener(l)
+	mux := http.NewServeMux()
+	mux.Handle(path, wl)
+	wl.s = &http.Server{Handler: mux, ReadHeaderTimeout: tlsHandshakeTimeout}
+	go wl.s.Serve(l)
+
+	if l, err := NewListener(name, wl, mf, r, server); err != nil {
+		wl.Close()
+		return err
+	} else if err := r.AddListener(l); err != nil {
+		l.Stop()
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+// Copyright (C) Tao Ma(tao.ma.1984@gmail.com), All rights reserved.
+// https://github.com/Tao-Ma/rpc/
+
+package rpc
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouterWebSocket(t *testing.T) {
+	interval := wsPingInterval
+	wsPingInterval = 50 * time.Millisecond
+	defer func() { wsPingInterval = interval }()
+
+	pf := NewJSONFactory()
+	pf.Register("echo", func() Payload { return new(jsonReq) }, func() Payload { return new(jsonReq) })
+	hf := NewRPCHeaderFactory(pf)
+
+	network := "tcp"
+	address := "localhost:10025"
+
+	server, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	server.HandleRPC("echo", func(ctx *RPCContext, p Payload) (Payload, error) {
+		return p, nil
+	})
+	server.Run()
+	defer server.Stop()
+
+	client, err := NewRouter(nil, JSONProcessPayload)
+	if err != nil {
+		t.FailNow()
+	}
+	client.Run()
+	defer client.Stop()
+
+	if err := server.ListenWebSocket("client", network, address, "/rpc", hf, nil); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if err := client.DialWebSocket("server", "ws://"+address+"/rpc", hf, nil); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if p, err := client.CallWait("server", "echo", &jsonReq{Id: 1}, 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if resp, ok := p.(*jsonReq); !ok || resp.Id != 1 {
+		t.Log(p)
+		t.FailNow()
+	}
+
+	// large message
+	name := strings.Repeat("x", 1<<20)
+	if p, err := client.CallWait("server", "echo", &jsonReq{Id: 2, Name: name}, 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	} else if resp, ok := p.(*jsonReq); !ok || resp.Id != 2 || resp.Name != name {
+		t.FailNow()
+	}
+
+	// idle connection is kept alive by ping/pong
+	time.Sleep(10 * wsPingInterval)
+	if _, err := client.CallWait("server", "echo", &jsonReq{Id: 3}, 5); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// not WebSocket
+	if resp, err := http.Get("http://" + address + "/rpc"); err != nil || resp.StatusCode != http.StatusUpgradeRequired {
+		t.Log(resp, err)
+		t.FailNow()
+	}
+	if err := client.DialWebSocket("nowhere", "ws://"+address+"/none", hf, nil); err != ErrWebSocketHandshake {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// close
+	if err := client.DelEndPoint("server"); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := client.CallWait("server", "echo", &jsonReq{Id: 4}, 1); err == nil {
+		t.FailNow()
+	}
+}
+
+func TestWebSocketDeadPeer(t *testing.T) {
+	interval := wsPingInterval
+	wsPingInterval = 50 * time.Millisecond
+	defer func() { wsPingInterval = interval }()
+
+	// the peer never answers
+	a, b := net.Pipe()
+	defer b.Close()
+	ws := newWSConn(a, bufio.NewReader(a), false)
+	defer ws.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ws.Read(make([]byte, 16))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Log(err)
+			t.FailNow()
+		}
+	case <-time.After(time.Second):
+		t.Log("no timeout")
+		t.FailNow()
+	}
+}
+
+func TestWebSocketInvalidFrame(t *testing.T) {
+	for _, h := range [][]byte{
+		{0x82, 0x80 | 127, 0x80, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4},  // negative length
+		{0xc2, 0x80, 1, 2, 3, 4},                                   // RSV bits
+		{0x83, 0x80, 1, 2, 3, 4},                                   // reserved data opcode
+		{0x8b, 0x80, 1, 2, 3, 4},                                   // reserved control opcode
+		{0x80, 0x81, 0, 0, 0, 0, 'x'},                              // continuation without message
+		{0x02, 0x81, 0, 0, 0, 0, 'x', 0x82, 0x81, 0, 0, 0, 0, 'y'}, // new message before the final frame
+	} {
+		a, b := net.Pipe()
+		ws := newWSConn(a, bufio.NewReader(a), false)
+		go io.Copy(ioutil.Discard, b)
+		go b.Write(h)
+
+		var err error
+		for i := 0; i < 4 && err == nil; i++ {
+			_, err = ws.Read(make([]byte, 16))
+		}
+		if err != ErrWebSocketProtocol {
+			t.Log(h, err)
+			t.FailNow()
+		}
+		ws.Close()
+		b.Close()
+	}
+}
+
+func TestWebSocketFragmentedMessage(t *testing.T) {
+	interval := wsPingInterval
+	wsPingInterval = 50 * time.Millisecond
+	defer func() { wsPingInterval = interval }()
+
+	a, b := net.Pipe()
+	defer b.Close()
+	ws := newWSConn(a, bufio.NewReader(a), false)
+	defer ws.Close()
+	go io.Copy(ioutil.Discard, b)
+
+	go func() {
+		// a ping between the fragments
+		b.Write([]byte{0x02, 0x81, 0, 0, 0, 0, 'x', 0x89, 0x80, 0, 0, 0, 0, 0x00, 0x81, 0, 0, 0, 0, 'y'})
+		// the frame is sent slower than the read deadline
+		b.Write([]byte{0x80, 0x86, 0, 0, 0, 0})
+		for _, c := range []byte("zzzzzz") {
+			time.Sleep(40 * time.Millisecond)
+			b.Write([]byte{c})
+		}
+	}()
+
+	var msg []byte
+	for len(msg) < 8 {
+		buf := make([]byte, 16)
+		n, err := ws.Read(buf)
+		if err != nil {
+			t.Log(string(msg), err)
+			t.FailNow()
+		}
+		msg = append(msg, buf[:n]...)
+	}
+	if string(msg) != "xyzzzzzz" {
+		t.Log(string(msg))
+		t.FailNow()
+	}
+}
@@ -50,6 +50,12 @@ type Writer struct {
 
 	rm *ResourceManager
 
+	// queues of each priority, outs[PRIORITY_NORMAL] is io.Out().
+	outs  [PRIORITY_LEVELS]chan Payload
+	sched *prioScheduler
+
+	local frameConn // passes messages instead of bytes
+
 	// buffer cache
 	maxlen         int
 	b              []byte
@@ -59,6 +65,13 @@ type Writer struct {
 	tch            <-chan time.Time
 	timeout        time.Duration
 
+	maxmsg int
+
+	// fragmented messages in progress
+	fragsize int
+	fragid   uint64
+	frags    []*fragment
+
 	flush chan struct{}
 
 	// inprogress state
@@ -80,16 +93,26 @@ func NewWriter(conn io.WriteCloser, io IOChannel, mb MsgBuffer, logger *log.Logg
 
 	w.conn = conn
 	w.mb = mb
+	w.local, _ = conn.(frameConn)
 
 	w.io = io
 
 	w.rm = NewResourceManager(128, func() Resource { return NewPayloadChan(io.Out()) })
 
+	w.outs[PRIORITY_NORMAL] = io.Out()
+	if pc, ok := io.(PriorityChannel); ok {
+		for i := PRIORITY_NORMAL + 1; i < PRIORITY_LEVELS; i++ {
+			w.outs[i] = pc.OutQueue(Priority(i))
+		}
+	}
+
 	w.maxlen = 128 * 1024
 	w.b = make([]byte, w.maxlen*2)
 	w.ob = w.b
 
 	w.SetFlushTimeout(10)
+	w.SetFragmentSize(w.maxlen / 2)
+	w.SetMaxMessageSize(0)
 
 	w.flush = make(chan struct{}, 1)
 
@@ -154,22 +177,73 @@ func (w *Writer) write(force bool) error {
 }
 
 func (w *Writer) LoopOnce(q chan struct{}) error {
+	if w.local != nil {
+		return w.forward(q)
+	}
+
 	var force bool
 
 	for {
-		select {
-		case <-q:
-			// TODO: flush
-			return errQuit
-		case <-w.tch:
-			// timeout
-			w.tch = nil
-			force = true
+		if len(w.frags) > 0 {
+			// Interleave: one chunk of each fragmented message per
+			// message, so huge message does not block others.
+			select {
+			case <-q:
+				return errQuit
+			default:
+			}
+			// the peer accepts maxFragments in progress.
+			if len(w.frags) < maxFragments {
+				if p, ok := w.sched.next(&w.outs); ok {
+					if p == nil {
+						return errQuit
+					}
+					if err := w.Marshal(p); err != nil {
+						return err
+					}
+				}
+			}
+
+			if err := w.marshalFragments(); err != nil {
+				return err
+			}
+
+			if !w.ShouldFlush() && len(w.frags) > 0 {
+				continue
+			}
 
-		case <-w.flush:
 			force = true
+			break
+		}
+
+		// The higher priority message which is already queued is
+		// marshaled first.
+		p, ok := w.sched.next(&w.outs)
+		if !ok {
+			select {
+			case <-q:
+				// TODO: flush
+				return errQuit
+			case <-w.tch:
+				// timeout
+				w.tch = nil
+				force = true
+
+			case <-w.flush:
+				force = true
+
+			case p = <-w.outs[PRIORITY_HIGHEST]:
+				ok = true
+			case p = <-w.outs[PRIORITY_HIGHER]:
+				ok = true
+			case p = <-w.outs[PRIORITY_HIGH]:
+				ok = true
+			case p = <-w.outs[PRIORITY_NORMAL]:
+				ok = true
+			}
+		}
 
-		case p := <-w.io.Out():
+		if ok {
 			if p == nil {
 				return errQuit
 			}
@@ -224,6 +298,34 @@ func (w *Writer) SetFlushTimeout(to int) {
 	w.timeout = time.Duration(to) * time.Microsecond
 }
 
+// SetFragmentSize sets the chunk size of CONTINUATION frames, the message
+// larger than n is fragmented. 0 disables fragmentation.
+func (w *Writer) SetFragmentSize(n int) {
+	if w.local != nil {
+		// the message of frameConn is passed as a whole.
+		n = 0
+	}
+	if n > w.maxlen {
+		n = w.maxlen
+	}
+	w.fragsize = n
+}
+
+// SetMaxMessageSize sets the max size of message, the larger one fails with
+// ErrMessageTooLarge. 0 means the default(256MB).
+func (w *Writer) SetMaxMessageSize(n int) {
+	if n <= 0 {
+		n = defaultMaxMessageSize
+	}
+	w.maxmsg = n
+}
+
+// SetPriorityWeights sets the scheduling between priority queues, nil is
+// strict(see Router.SetPriorityWeights).
+func (w *Writer) SetPriorityWeights(weights []int) {
+	w.sched = newPrioScheduler(weights)
+}
+
 func (w *Writer) Flush() {
 	if w.timeout > 0 {
 		return
@@ -240,7 +342,11 @@ func (w *Writer) Write(p Payload) error {
 		return ErrWriterClosed
 	}
 
-	ch.ch <- p
+	if pi, ok := p.(PriorityInfo); ok && w.outs[pi.GetPriority()] != nil {
+		w.outs[pi.GetPriority()] <- p
+	} else {
+		ch.ch <- p
+	}
 
 	ch.Recycle()
 
@@ -283,24 +389,86 @@ func (w *Writer) Marshal(p Payload) error {
 		return err
 	}
 
-	// TODO: zero length payload
-	if len(npb) == 0 {
-		panic("don't support zero length payload")
+	if l := len(hb) + len(npb); l > w.maxmsg && w.local == nil {
+		// drop it, only the sender fails.
+		w.b_alloc_offset -= len(hb)
+		if mc, ok := w.io.(msgErrorChannel); ok {
+			mc.OutMsgError(w.mb, ErrMessageTooLarge)
+		}
+		return nil
 	}
 
 	if err := w.mb.MarshalHeader(hb, p, uint32(len(npb))); err != nil {
 		return err
 	}
 
+	if len(npb) == 0 {
+		// zero length payload, header only.
+		return nil
+	}
+
 	if &npb[0] == &pb[0] {
-		// unchanged
-		w.allocBuf(uint32(len(npb)))
+		// unchanged, the payload is already in place, don't rewind.
+		w.b_alloc_offset += len(npb)
+	} else {
+		// changed
+		w.b = append(w.b[w.b_data_offset:w.b_alloc_offset], npb...)
+		w.b_data_offset = 0
+		w.b_alloc_offset = len(w.b)
+	}
+
+	return w.fragment(int(w.mb.GetHdrLen()) + len(npb))
+}
+
+// fragment moves the last marshaled message(l bytes) out of buffer if it is
+// larger than fragment size, it will be sent by CONTINUATION frames.
+func (w *Writer) fragment(l int) error {
+	if w.fragsize <= 0 || l <= w.fragsize {
 		return nil
 	}
+	if _, ok := w.mb.(FrameBuffer); !ok {
+		return nil
+	}
+
+	w.fragid++
+	f := &fragment{id: w.fragid, b: make([]byte, l)}
+
+	aoff := w.b_alloc_offset
+	copy(f.b, w.b[aoff-l:aoff])
+	w.b_alloc_offset = aoff - l
+
+	w.frags = append(w.frags, f)
+	return nil
+}
+
+// marshalFragments marshals one chunk of each fragmented message until the
+// buffer is full.
+func (w *Writer) marshalFragments() error {
+	fb := w.mb.(FrameBuffer)
+
+	for n := len(w.frags); n > 0 && w.b_alloc_offset-w.b_data_offset < w.maxlen; n-- {
+		f := w.frags[0]
+		w.frags = w.frags[1:]
+
+		l := len(f.b) - f.off
+		if l > w.fragsize {
+			l = w.fragsize
+		}
+		end := f.off+l == len(f.b)
+
+		hb := w.allocBuf(w.mb.GetHdrLen())
+		if err := fb.MarshalContinuation(hb, f.id, uint32(l), end); err != nil {
+			return err
+		}
+		cb := w.allocBuf(uint32(l))
+		copy(cb, f.b[f.off:f.off+l])
+		f.off += l
+
+		if !end {
+			// round robin
+			w.frags = append(w.frags, f)
+		}
+	}
 
-	// changed
-	w.b = append(w.b[w.b_data_offset:w.b_alloc_offset], npb...)
-	w.b_data_offset = 0
-	w.b_alloc_offset = len(w.b)
 	return nil
 }